@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config is the part of balto.config.yaml that main reads. Other sections
+// are ignored until they are implemented.
+type config struct {
+	Proxy     proxyConfig     `yaml:"proxy"`
+	Discovery discoveryConfig `yaml:"discovery"`
+	Admin     adminConfig     `yaml:"admin"`
+}
+
+// adminConfig configures the admin API.
+type adminConfig struct {
+	// AllowedOrigins may open the event stream over WebSocket besides pages
+	// from the admin host itself.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// proxyConfig holds the proxy.Options of the forwarding listener.
+type proxyConfig struct {
+	Forwarded bool   `yaml:"forwarded"` // append an RFC 7239 Forwarded element
+	Via       string `yaml:"via"`       // pseudonym added to Via, none when empty
+	// TrustedProxies are CIDRs or IPs whose forwarding headers are believed,
+	// see proxy.ParseTrustedProxies.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// discoveryConfig lists the discovery providers to run besides the service
+// files.
+type discoveryConfig struct {
+	DNS        []dnsConfig      `yaml:"dns"`
+	Docker     dockerConfig     `yaml:"docker"`
+	Kubernetes kubernetesConfig `yaml:"kubernetes"`
+	Consul     []consulConfig   `yaml:"consul"`
+}
+
+// poolRoute is the route whose pool a DNS or Consul provider fills. The
+// route starts with an empty pool, so Balto starts while the source is
+// down or empty.
+type poolRoute struct {
+	Host string `yaml:"host"`
+	Path string `yaml:"path"` // defaults to "/"
+}
+
+type dnsConfig struct {
+	poolRoute `yaml:",inline"`
+	Name      string        `yaml:"name"`
+	SRV       bool          `yaml:"srv"`
+	Port      int           `yaml:"port"`
+	Scheme    string        `yaml:"scheme"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+type consulConfig struct {
+	poolRoute  `yaml:",inline"`
+	Address    string `yaml:"address"`
+	Service    string `yaml:"service"`
+	Tag        string `yaml:"tag"`
+	Datacenter string `yaml:"datacenter"`
+	Token      string `yaml:"token"`
+	Scheme     string `yaml:"scheme"`
+}
+
+// dockerConfig turns on routes from container labels.
+type dockerConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Host    string `yaml:"host"` // Engine API, discovery.DefaultDockerHost when empty
+}
+
+// kubernetesConfig turns on ingress controller mode. Without an API server,
+// the pod's service account is used.
+type kubernetesConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	APIServer    string `yaml:"api_server"`
+	TokenFile    string `yaml:"token_file"`
+	Namespace    string `yaml:"namespace"`
+	IngressClass string `yaml:"ingress_class"`
+}
+
+// loadConfig reads the config file at path. A missing file yields the
+// defaults.
+func loadConfig(path string) (config, error) {
+	var cfg config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
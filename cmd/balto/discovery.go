@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/discovery"
+	"github.com/diabeney/balto/internal/router"
+)
+
+// addPoolRoute adds the route r to rt with an empty pool for a provider to
+// fill, and returns the router with it and the pool.
+func addPoolRoute(rt *router.Router, r poolRoute) (*router.Router, *backendpool.Pool, error) {
+	host, path := router.Host(r.Host), r.Path
+	if path == "" {
+		path = "/"
+	}
+	next, err := rt.Insert(host, path, nil, router.RouteOptions{Discovered: true})
+	if err != nil {
+		return rt, nil, err
+	}
+	key := router.RouteKey(host, path)
+	for _, ri := range next.Routes() {
+		if ri.Key == key {
+			return next, ri.Route.Pool, nil
+		}
+	}
+	return rt, nil, fmt.Errorf("route %s was not added", key)
+}
+
+// addDNS adds a route per DNS entry to rt and keeps its pool in sync with
+// the name until ctx is done. Lookups that fail, at startup or later, are
+// published on discovery.error and retried every interval.
+func addDNS(ctx context.Context, rt *router.Router, entries []dnsConfig) (*router.Router, error) {
+	for _, e := range entries {
+		next, pool, err := addPoolRoute(rt, e.poolRoute)
+		if err != nil {
+			return rt, fmt.Errorf("dns discovery: %w", err)
+		}
+		d, err := discovery.NewDNS(discovery.DNSConfig{Name: e.Name, SRV: e.SRV, Port: e.Port, Scheme: e.Scheme, Interval: e.Interval}, pool)
+		if err != nil {
+			return rt, err
+		}
+		rt = next
+		go d.Run(ctx)
+	}
+	return rt, nil
+}
+
+// addConsul adds a route per Consul entry to rt and keeps its pool in sync
+// with the service's passing instances until ctx is done. Failed queries
+// are published on discovery.error and retried.
+func addConsul(ctx context.Context, rt *router.Router, entries []consulConfig) (*router.Router, error) {
+	for _, e := range entries {
+		next, pool, err := addPoolRoute(rt, e.poolRoute)
+		if err != nil {
+			return rt, fmt.Errorf("consul discovery: %w", err)
+		}
+		c, err := discovery.NewConsul(discovery.ConsulConfig{
+			Address: e.Address, Service: e.Service, Tag: e.Tag,
+			Datacenter: e.Datacenter, Token: e.Token, Scheme: e.Scheme,
+		}, pool)
+		if err != nil {
+			return rt, err
+		}
+		rt = next
+		go c.Run(ctx)
+	}
+	return rt, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,16 +10,43 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/diabeney/balto/internal/admin"
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/internal/discovery"
 	"github.com/diabeney/balto/internal/proxy"
 	"github.com/diabeney/balto/internal/router"
 	"github.com/diabeney/balto/internal/server"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+	"github.com/diabeney/balto/ui/web/dashboard"
 )
 
 func main() {
+	configPath := flag.String("config", "configs/balto.config.yaml", "path to the config file")
+	flag.Parse()
+
+	conf, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 
 	defer stop()
 
+	events := pubsub.Default().Subscribe(256,
+		backendpool.TopicBackendDown,
+		backendpool.TopicBackendRecovered,
+		backendpool.TopicDrainStarted,
+		backendpool.TopicDrainFinished,
+		backendpool.TopicDrainTimedOut,
+		circuit.TopicStateChange,
+		router.TopicReload,
+		discovery.TopicError,
+	)
+	defer events.Close()
+	go logEvents(events)
+
 	cfg := []router.InitialRoutes{
 		{Domain: "localhost", PathPrefix: "*", Ports: []string{"8080", "8081", "8082", "8083", "8084"}},
 	}
@@ -29,21 +57,99 @@ func main() {
 		log.Fatalf("Failed to build router: %v", err)
 	}
 
+	rt, err = addDNS(ctx, rt, conf.Discovery.DNS)
+	if err != nil {
+		log.Fatalf("Failed to set up discovery: %v", err)
+	}
+	rt, err = addConsul(ctx, rt, conf.Discovery.Consul)
+	if err != nil {
+		log.Fatalf("Failed to set up discovery: %v", err)
+	}
+
 	rt.Start()
 
 	router.SetCurrent(rt)
 
-	px := proxy.New(router.Current())
+	trusted, err := proxy.ParseTrustedProxies(conf.Proxy.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	px := proxy.NewWithOptions(router.Current(), proxy.Options{
+		Forwarded:      conf.Proxy.Forwarded,
+		Via:            conf.Proxy.Via,
+		TrustedProxies: trusted,
+	})
+
+	// Every provider that manages routes applies its changes to this one
+	// router, so none of them drops the others' routes.
+	shared := discovery.NewSharedRouter(rt, func(next *router.Router) {
+		router.SetCurrent(next)
+		px.UpdateRouter(next)
+	})
+
+	//TODO: Load the services directory from config
+	files, err := discovery.NewFiles(discovery.FileConfig{
+		Dir:    "configs/services",
+		Shared: shared,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up service files: %v", err)
+	}
+	if err := files.Refresh(); err != nil {
+		log.Printf("Service files: %v", err)
+	}
+	go files.Run(ctx)
+
+	if conf.Discovery.Docker.Enabled {
+		docker, err := discovery.NewDocker(discovery.DockerConfig{
+			Host:   conf.Discovery.Docker.Host,
+			Shared: shared,
+		})
+		if err != nil {
+			log.Fatalf("Failed to set up Docker discovery: %v", err)
+		}
+		go docker.Run(ctx)
+	}
+
+	if k := conf.Discovery.Kubernetes; k.Enabled {
+		kcfg := discovery.KubernetesConfig{APIServer: k.APIServer, TokenFile: k.TokenFile}
+		if k.APIServer == "" {
+			kcfg, err = discovery.InClusterConfig()
+			if err != nil {
+				log.Fatalf("Failed to set up Kubernetes discovery: %v", err)
+			}
+		}
+		kcfg.Namespace, kcfg.IngressClass = k.Namespace, k.IngressClass
+		kcfg.Shared = shared
+		kube, err := discovery.NewKubernetes(kcfg)
+		if err != nil {
+			log.Fatalf("Failed to set up Kubernetes discovery: %v", err)
+		}
+		go kube.Run(ctx)
+	}
 
 	//TODO: Load port from config
 	srv := server.New(":80", http.HandlerFunc(px.ServeHTTP))
 
+	//TODO: Load admin port from config
+	adminAPI := admin.New(pubsub.Default(), router.Current)
+	adminAPI.AllowOrigins(conf.Admin.AllowedOrigins...)
+	// nil unless built with -tags dashboard
+	adminAPI.MountDashboard(dashboard.FS())
+	admin := server.New(":9090", adminAPI)
+
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
+	go func() {
+		if err := admin.Start(); err != nil {
+			log.Fatalf("Admin server error: %v", err)
+		}
+	}()
+
 	<-ctx.Done()
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -60,5 +166,16 @@ func main() {
 		log.Printf("Shutdown error: %v", err)
 	}
 
+	adminAPI.Close()
+	if err := admin.Stop(shutdownCtx); err != nil {
+		log.Printf("Admin shutdown error: %v", err)
+	}
+
 	log.Println("Balto server stopped.")
 }
+
+func logEvents(sub *pubsub.Subscription) {
+	for ev := range sub.C() {
+		log.Printf("%s: %v", ev.Topic, ev.Data)
+	}
+}
@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/diabeney/balto/internal/core"
+	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+// Server exposes Balto's runtime state to the dashboard. It is mounted on the
+// admin listener, never on the proxy listener.
+type Server struct {
+	bus     *pubsub.Bus
+	current func() *router.Router
+	mux     *http.ServeMux
+	origins map[string]bool // extra origins allowed to open a WebSocket
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New builds the admin API. current is consulted on every request so the API
+// always reflects the router that is serving traffic.
+func New(bus *pubsub.Bus, current func() *router.Router) *Server {
+	if bus == nil {
+		bus = pubsub.Default()
+	}
+	s := &Server{
+		bus:     bus,
+		current: current,
+		mux:     http.NewServeMux(),
+		done:    make(chan struct{}),
+	}
+	s.mux.HandleFunc("GET /api/events", s.handleEvents)
+	s.mux.HandleFunc("GET /api/health/backends", s.handleBackendHealth)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close ends every open event stream. Long-lived streams would otherwise hold
+// up a graceful shutdown of the admin listener.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+type BackendState struct {
+	ID          string        `json:"id"`
+	URL         string        `json:"url"`
+	Healthy     bool          `json:"healthy"`
+	Draining    bool          `json:"draining"`
+	Circuit     circuit.State `json:"circuit"`
+	ActiveConns uint64        `json:"active_conns"`
+}
+
+type RouteState struct {
+	Key      string         `json:"key"`
+	Host     string         `json:"host"`
+	Path     string         `json:"path"`
+	Backends []BackendState `json:"backends"`
+}
+
+// routeStates captures the current state of every route accepted by f.
+func (s *Server) routeStates(f filter) []RouteState {
+	out := []RouteState{}
+	rt := s.currentRouter()
+	if rt == nil {
+		return out
+	}
+	for _, ri := range rt.Routes() {
+		if !f.matchRoute(ri) {
+			continue
+		}
+		rs := RouteState{Key: ri.Key, Host: string(ri.Host), Path: normalizePath(ri.Route.Prefix), Backends: []BackendState{}}
+		if ri.Route.Pool != nil {
+			for _, b := range ri.Route.Pool.List() {
+				rs.Backends = append(rs.Backends, backendState(b))
+			}
+		}
+		out = append(out, rs)
+	}
+	return out
+}
+
+func backendState(b *core.Backend) BackendState {
+	bs := BackendState{
+		ID:       b.ID,
+		Healthy:  b.IsHealthy(),
+		Draining: b.IsDraining(),
+	}
+	if b.URL != nil {
+		bs.URL = b.URL.String()
+	}
+	if b.Circuit != nil {
+		bs.Circuit = b.Circuit.State()
+	}
+	if b.Meta != nil {
+		bs.ActiveConns = b.Meta.Active()
+	}
+	return bs
+}
+
+func (s *Server) currentRouter() *router.Router {
+	if s.current == nil {
+		return nil
+	}
+	return s.current()
+}
@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheAsset      = "public, max-age=3600"
+	cacheRevalidate = "no-cache"
+)
+
+// MountDashboard serves the exported dashboard from fsys on every path the API
+// does not claim. Unknown paths without a file extension fall back to
+// index.html so client-side routes survive a page reload.
+func (s *Server) MountDashboard(fsys fs.FS) {
+	if fsys == nil {
+		return
+	}
+	s.mux.Handle("GET /", &dashboardHandler{fsys: fsys})
+}
+
+type dashboardHandler struct {
+	fsys fs.FS
+}
+
+func (d *dashboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	// Never answer API misses with the SPA shell.
+	if name == "api" || strings.HasPrefix(name, "api/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	for _, candidate := range d.candidates(name) {
+		if d.serveFile(w, r, candidate) {
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// candidates lists the files that may answer a request for name, in order.
+// Next.js exports "/about" as about.html (or about/index.html with trailing
+// slashes enabled).
+func (d *dashboardHandler) candidates(name string) []string {
+	if name == "" {
+		return []string{"index.html"}
+	}
+	if path.Ext(name) != "" {
+		return []string{name}
+	}
+	return []string{name, name + ".html", path.Join(name, "index.html"), "index.html"}
+}
+
+func (d *dashboardHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := d.fsys.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	w.Header().Set("Cache-Control", cacheControl(name))
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, info.ModTime(), rs)
+		return true
+	}
+
+	// Fall back to buffering for file systems without Seek.
+	data, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return true
+	}
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	return true
+}
+
+// cacheControl lets browsers keep content-hashed build output forever while
+// always revalidating HTML, which references those hashed names.
+func cacheControl(name string) string {
+	switch {
+	case strings.HasPrefix(name, "_next/static/"):
+		return cacheImmutable
+	case path.Ext(name) == ".html":
+		return cacheRevalidate
+	default:
+		return cacheAsset
+	}
+}
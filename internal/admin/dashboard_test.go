@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDashboardServing(t *testing.T) {
+	s, _, _ := newTestAPI(t)
+	defer s.Close()
+	s.MountDashboard(fstest.MapFS{
+		"index.html":            {Data: []byte("<html>index</html>")},
+		"routes.html":           {Data: []byte("<html>routes</html>")},
+		"_next/static/app-1.js": {Data: []byte("console.log(1)")},
+		"favicon.ico":           {Data: []byte("ico")},
+		"backends/index.html":   {Data: []byte("<html>backends</html>")},
+	})
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+		cache  string
+	}{
+		{"/", http.StatusOK, "<html>index</html>", cacheRevalidate},
+		{"/routes", http.StatusOK, "<html>routes</html>", cacheRevalidate},
+		{"/backends", http.StatusOK, "<html>backends</html>", cacheRevalidate},
+		{"/routes/a.com/api", http.StatusOK, "<html>index</html>", cacheRevalidate},
+		{"/_next/static/app-1.js", http.StatusOK, "console.log(1)", cacheImmutable},
+		{"/favicon.ico", http.StatusOK, "ico", cacheAsset},
+		{"/missing.js", http.StatusNotFound, "", ""},
+		{"/api/unknown", http.StatusNotFound, "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+			resp := w.Result()
+			if resp.StatusCode != c.status {
+				t.Fatalf("expected %d, got %d", c.status, resp.StatusCode)
+			}
+			if c.status != http.StatusOK {
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != c.body {
+				t.Errorf("expected body %q, got %q", c.body, body)
+			}
+			if got := resp.Header.Get("Cache-Control"); got != c.cache {
+				t.Errorf("expected Cache-Control %q, got %q", c.cache, got)
+			}
+		})
+	}
+}
+
+func TestDashboardNotMountedByDefault(t *testing.T) {
+	s, _, _ := newTestAPI(t)
+	defer s.Close()
+	s.MountDashboard(nil)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without dashboard, got %d", w.Code)
+	}
+}
@@ -0,0 +1,238 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/internal/health"
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+const (
+	// TopicSnapshot is the first message on every stream. Its payload is the
+	// current []RouteState so clients never start from an empty view.
+	TopicSnapshot = "snapshot"
+	// TopicDropped tells the client it missed events and should resync.
+	TopicDropped = "stream.dropped"
+
+	heartbeatInterval = 15 * time.Second
+	streamBufferSize  = 256
+)
+
+// defaultTopics are streamed when the client does not ask for specific ones.
+// Probe results are opt-in because they fire on every probe of every backend.
+var defaultTopics = []pubsub.Topic{
+	backendpool.TopicBackendDown,
+	backendpool.TopicBackendRecovered,
+	backendpool.TopicDrainStarted,
+	backendpool.TopicDrainFinished,
+	backendpool.TopicDrainTimedOut,
+	circuit.TopicStateChange,
+	router.TopicReload,
+}
+
+type message struct {
+	ID    uint64    `json:"id"`
+	Topic string    `json:"topic"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// sink is a transport the event stream writes to (SSE or WebSocket).
+type sink interface {
+	send(m message) error
+	ping() error
+}
+
+type filter struct {
+	host   string
+	path   string
+	topics []pubsub.Topic
+}
+
+func parseFilter(r *http.Request) filter {
+	q := r.URL.Query()
+	f := filter{
+		host:   strings.ToLower(strings.TrimSpace(q.Get("host"))),
+		topics: defaultTopics,
+	}
+	if p := strings.TrimSpace(q.Get("route")); p != "" {
+		f.path = normalizePath(p)
+	}
+	if t := q.Get("topics"); t != "" {
+		f.topics = nil
+		for _, name := range strings.Split(t, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				f.topics = append(f.topics, pubsub.Topic(name))
+			}
+		}
+	}
+	return f
+}
+
+func (f filter) match(host, path string) bool {
+	if f.host != "" && f.host != host {
+		return false
+	}
+	if f.path != "" && f.path != normalizePath(path) {
+		return false
+	}
+	return true
+}
+
+func (f filter) matchRoute(ri router.RouteInfo) bool {
+	return f.match(string(ri.Host), ri.Route.Prefix)
+}
+
+// matchPool reports whether f accepts the route of the pool named name in
+// rt. Pools are named after their route key. Pools of routes that rt no
+// longer has match only an unfiltered stream.
+func (f filter) matchPool(rt *router.Router, name string) bool {
+	if f.host == "" && f.path == "" {
+		return true
+	}
+	if rt == nil {
+		return false
+	}
+	for _, ri := range rt.Routes() {
+		if ri.Key == name {
+			return f.matchRoute(ri)
+		}
+	}
+	return false
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	f := parseFilter(r)
+	if isWebSocketUpgrade(r) {
+		s.serveWebSocket(w, r, f)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the listener's write timeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	s.stream(r.Context(), f, &sseSink{w: w, rc: rc})
+}
+
+// stream replays the current state and then forwards matching bus events to
+// out until ctx is done or the client goes away.
+func (s *Server) stream(ctx context.Context, f filter, out sink) {
+	// Subscribe before taking the snapshot so nothing falls in between.
+	sub := s.bus.Subscribe(streamBufferSize, f.topics...)
+	defer sub.Close()
+
+	var seq uint64
+	next := func(topic string, at time.Time, data any) message {
+		seq++
+		return message{ID: seq, Topic: topic, Time: at, Data: data}
+	}
+
+	if err := out.send(next(TopicSnapshot, time.Now(), s.routeStates(f))); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	var dropped uint64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case ev, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			if !f.matchEvent(ev, s.currentRouter()) {
+				continue
+			}
+			if err := out.send(next(string(ev.Topic), ev.Time, ev.Data)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if d := sub.Dropped(); d != dropped {
+				dropped = d
+				if err := out.send(next(TopicDropped, time.Now(), map[string]uint64{"dropped": d})); err != nil {
+					return
+				}
+			}
+			if err := out.ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// matchEvent reports whether f accepts ev, looking up the route of pool
+// events in rt.
+func (f filter) matchEvent(ev pubsub.Event, rt *router.Router) bool {
+	if f.host == "" && f.path == "" {
+		return true
+	}
+	switch d := ev.Data.(type) {
+	case backendpool.BackendEvent:
+		return f.matchPool(rt, d.Pool)
+	case health.ProbeResult:
+		return f.matchPool(rt, d.Pool)
+	case circuit.StateChange:
+		return f.matchPool(rt, d.Pool)
+	default:
+		// Router-wide events such as reloads concern every route.
+		return true
+	}
+}
+
+type sseSink struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s *sseSink) send(m message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", m.ID, m.Topic, data); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *sseSink) ping() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func normalizePath(p string) string {
+	if p == "" || p == "/" {
+		return "/"
+	}
+	p = strings.TrimSuffix(p, "/")
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
@@ -0,0 +1,281 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+func newTestAPI(t *testing.T) (*Server, *pubsub.Bus, *router.Router) {
+	t.Helper()
+	rt := router.NewRouter()
+	rt = rt.Add(router.Host("a.com"), "/api", []*url.URL{{Scheme: "http", Host: "localhost:3001"}})
+	rt = rt.Add(router.Host("b.com"), "/", []*url.URL{{Scheme: "http", Host: "localhost:3002"}})
+	bus := pubsub.New()
+	return New(bus, func() *router.Router { return rt }), bus, rt
+}
+
+type sseEvent struct {
+	name string
+	msg  message
+	raw  json.RawMessage
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if ev.name != "" {
+				return ev
+			}
+		case strings.HasPrefix(line, "event: "):
+			ev.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var env struct {
+				message
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &env); err != nil {
+				t.Fatalf("bad data line %q: %v", line, err)
+			}
+			ev.msg = env.message
+			ev.raw = env.Data
+		}
+	}
+}
+
+func waitSubscribers(t *testing.T, bus *pubsub.Bus, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for bus.Subscribers() < n {
+		if time.Now().After(deadline) {
+			t.Fatal("stream did not subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventStreamSSE(t *testing.T) {
+	s, bus, _ := newTestAPI(t)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/events?host=a.com&route=/api")
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	t.Run("Snapshot replays filtered state", func(t *testing.T) {
+		ev := readSSE(t, r)
+		if ev.name != TopicSnapshot {
+			t.Fatalf("expected snapshot first, got %s", ev.name)
+		}
+		var routes []RouteState
+		if err := json.Unmarshal(ev.raw, &routes); err != nil {
+			t.Fatalf("decoding snapshot: %v", err)
+		}
+		if len(routes) != 1 || routes[0].Key != "a.com/api" {
+			t.Fatalf("expected only a.com/api, got %+v", routes)
+		}
+		if len(routes[0].Backends) != 1 || !routes[0].Backends[0].Healthy {
+			t.Errorf("unexpected backends %+v", routes[0].Backends)
+		}
+	})
+
+	t.Run("Events are filtered by host and route", func(t *testing.T) {
+		waitSubscribers(t, bus, 1)
+		bus.Publish(backendpool.TopicBackendDown, backendpool.BackendEvent{Pool: "b.com/", Backend: "other"})
+		bus.Publish(backendpool.TopicBackendDown, backendpool.BackendEvent{Pool: "a.com/api", Backend: "mine"})
+		// Same backend ID on another route of the host: attributed by pool.
+		bus.Publish(circuit.TopicStateChange, circuit.StateChange{Pool: "a.com/other", Name: "a.com-http://localhost:3001", From: circuit.Closed, To: circuit.HalfOpen})
+		bus.Publish(circuit.TopicStateChange, circuit.StateChange{Pool: "a.com/api", Name: "a.com-http://localhost:3001", From: circuit.Closed, To: circuit.Open})
+
+		ev := readSSE(t, r)
+		if ev.name != string(backendpool.TopicBackendDown) {
+			t.Fatalf("expected backend.down, got %s", ev.name)
+		}
+		var be backendpool.BackendEvent
+		_ = json.Unmarshal(ev.raw, &be)
+		if be.Backend != "mine" {
+			t.Errorf("expected event for a.com only, got %+v", be)
+		}
+
+		ev = readSSE(t, r)
+		if ev.name != string(circuit.TopicStateChange) {
+			t.Fatalf("expected circuit.state, got %s", ev.name)
+		}
+		if !strings.Contains(string(ev.raw), `"to":"Open"`) {
+			t.Errorf("expected named state, got %s", ev.raw)
+		}
+	})
+}
+
+func TestFilterConditionalRoutes(t *testing.T) {
+	rt := router.NewRouter().
+		Add(router.Host("a.com"), "/api", []*url.URL{{Scheme: "http", Host: "localhost:3001"}}).
+		AddWithOptions(router.Host("a.com"), "/api/", []*url.URL{{Scheme: "http", Host: "localhost:3003"}},
+			router.RouteOptions{Match: &router.Match{Methods: []string{"POST"}}}).
+		Add(router.Host("a.com"), "/other", []*url.URL{{Scheme: "http", Host: "localhost:3002"}})
+	s := New(pubsub.New(), func() *router.Router { return rt })
+	defer s.Close()
+
+	routes := s.routeStates(parseFilter(httptest.NewRequest(http.MethodGet, "/api/events?host=a.com&route=/api", nil)))
+	if len(routes) != 2 {
+		t.Fatalf("expected the plain and the conditional /api routes, got %+v", routes)
+	}
+	for _, rs := range routes {
+		if rs.Host != "a.com" || rs.Path != "/api" {
+			t.Errorf("route %s: got host %q and path %q", rs.Key, rs.Host, rs.Path)
+		}
+	}
+
+	f := parseFilter(httptest.NewRequest(http.MethodGet, "/api/events?route=/api", nil))
+	for _, rs := range routes {
+		if !f.matchEvent(pubsub.Event{Data: backendpool.BackendEvent{Pool: rs.Key}}, rt) {
+			t.Errorf("event of %s filtered out", rs.Key)
+		}
+	}
+	if f.matchEvent(pubsub.Event{Data: backendpool.BackendEvent{Pool: router.RouteKey("a.com", "/other")}}, rt) {
+		t.Error("event of another route accepted")
+	}
+	if f.matchEvent(pubsub.Event{Data: backendpool.BackendEvent{Pool: "a.com/api [removed]"}}, rt) {
+		t.Error("event of an unknown pool accepted")
+	}
+}
+
+func TestEventStreamCloseEndsStream(t *testing.T) {
+	s, _, _ := newTestAPI(t)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("GET /api/events: %v", err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	readSSE(t, r)
+
+	s.Close()
+	done := make(chan struct{})
+	go func() {
+		_, _ = r.ReadString(0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still open after Close")
+	}
+}
+
+func TestEventStreamWebSocket(t *testing.T) {
+	s, bus, _ := newTestAPI(t)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /api/events?route=/ HTTP/1.1\r\nHost: admin\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("reading handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("bad accept key %q", got)
+	}
+
+	readMsg := func() message {
+		t.Helper()
+		op, payload, err := readFrame(r, 1<<20)
+		if err != nil {
+			t.Fatalf("reading frame: %v", err)
+		}
+		if op != opText {
+			t.Fatalf("expected text frame, got op %d", op)
+		}
+		var m message
+		if err := json.Unmarshal(payload, &m); err != nil {
+			t.Fatalf("decoding frame: %v", err)
+		}
+		return m
+	}
+
+	if m := readMsg(); m.Topic != TopicSnapshot {
+		t.Fatalf("expected snapshot, got %s", m.Topic)
+	}
+
+	waitSubscribers(t, bus, 1)
+	bus.Publish(router.TopicReload, router.ReloadEvent{Hosts: 2, Routes: 2})
+	if m := readMsg(); m.Topic != string(router.TopicReload) {
+		t.Errorf("expected router.reload, got %s", m.Topic)
+	}
+}
+
+func TestEventStreamWebSocketOrigin(t *testing.T) {
+	s, _, _ := newTestAPI(t)
+	defer s.Close()
+	s.AllowOrigins("https://ops.example.com/")
+
+	handshake := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://admin:9090/api/events", nil)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	for _, origin := range []string{"https://evil.example", "null", "http://admin"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, handshake(origin))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("origin %q: expected 403, got %d", origin, w.Code)
+		}
+	}
+	for _, origin := range []string{"", "http://admin:9090", "https://ADMIN:9090", "https://ops.example.com"} {
+		if !s.checkOrigin(handshake(origin)) {
+			t.Errorf("origin %q: rejected", origin)
+		}
+	}
+}
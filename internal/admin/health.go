@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/diabeney/balto/internal/health"
+)
+
+type BackendHealth struct {
+	BackendState
+	PassiveFailCount  uint64               `json:"passive_fail_count"`
+	ProbeFailCount    uint64               `json:"probe_fail_count"`
+	ProbeSuccessCount uint64               `json:"probe_success_count"`
+	LastSuccess       *time.Time           `json:"last_success,omitempty"`
+	LastFailure       *time.Time           `json:"last_failure,omitempty"`
+	Probes            []health.ProbeResult `json:"probes"`
+}
+
+type RouteHealth struct {
+	Key      string          `json:"key"`
+	Host     string          `json:"host"`
+	Path     string          `json:"path"`
+	Backends []BackendHealth `json:"backends"`
+}
+
+// handleBackendHealth returns health counters and the recent probe history of
+// every backend, optionally narrowed by host, route and backend ID.
+func (s *Server) handleBackendHealth(w http.ResponseWriter, r *http.Request) {
+	f := parseFilter(r)
+	backendID := r.URL.Query().Get("backend")
+
+	out := []RouteHealth{}
+	if rt := s.currentRouter(); rt != nil {
+		for _, ri := range rt.Routes() {
+			if !f.matchRoute(ri) || ri.Route.Pool == nil {
+				continue
+			}
+			rh := RouteHealth{Key: ri.Key, Host: string(ri.Host), Path: normalizePath(ri.Route.Prefix), Backends: []BackendHealth{}}
+			for _, b := range ri.Route.Pool.List() {
+				if backendID != "" && b.ID != backendID {
+					continue
+				}
+				bh := BackendHealth{BackendState: backendState(b), Probes: []health.ProbeResult{}}
+				if b.Meta != nil {
+					bh.PassiveFailCount = b.Meta.PassiveFailCount.Load()
+					bh.ProbeFailCount = b.Meta.ProbeFailCount.Load()
+					bh.ProbeSuccessCount = b.Meta.ProbeSuccessCount.Load()
+					bh.LastSuccess = unixNano(b.Meta.LastSuccess.Load())
+					bh.LastFailure = unixNano(b.Meta.LastFailure.Load())
+				}
+				if ri.Healthchecker != nil {
+					if probes := ri.Healthchecker.History(b.ID); probes != nil {
+						bh.Probes = probes
+					}
+				}
+				rh.Backends = append(rh.Backends, bh)
+			}
+			if backendID != "" && len(rh.Backends) == 0 {
+				continue
+			}
+			out = append(out, rh)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func unixNano(n int64) *time.Time {
+	if n == 0 {
+		return nil
+	}
+	t := time.Unix(0, n)
+	return &t
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
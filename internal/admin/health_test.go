@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+func TestBackendHealthEndpoint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+
+	cfg := router.DefaultPoolConfig()
+	cfg.ProbePath = "/"
+	cfg.ProbeInterval = 100
+	rt := router.NewRouter().
+		AddWithOptions(router.Host("a.com"), "/api", []*url.URL{u}, router.RouteOptions{Pool: &cfg}).
+		Add(router.Host("b.com"), "/", []*url.URL{{Scheme: "http", Host: "localhost:3002"}})
+	rt.Start()
+	defer func() { _ = rt.Stop() }()
+
+	s := New(pubsub.New(), func() *router.Router { return rt })
+	defer s.Close()
+
+	time.Sleep(400 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/health/backends?host=a.com", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var routes []RouteHealth
+	if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(routes) != 1 || routes[0].Key != "a.com/api" || len(routes[0].Backends) != 1 {
+		t.Fatalf("expected one a.com backend, got %+v", routes)
+	}
+	b := routes[0].Backends[0]
+	if len(b.Probes) == 0 {
+		t.Fatal("expected probe history")
+	}
+	if !b.Probes[len(b.Probes)-1].Healthy || b.LastSuccess == nil || b.ProbeSuccessCount == 0 {
+		t.Errorf("unexpected backend health %+v", b)
+	}
+
+	t.Run("Unknown backend filters everything", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/health/backends?backend=ghost", nil))
+		if body := w.Body.String(); body != "[]\n" {
+			t.Errorf("expected empty list, got %s", body)
+		}
+	})
+}
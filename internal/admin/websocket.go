@@ -0,0 +1,240 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal server side of RFC 6455, just enough to push the event stream to
+// browsers that prefer WebSockets over SSE. Client messages are ignored apart
+// from ping and close.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowOrigins lets pages served from origins, e.g. "https://ops.example.com",
+// open the event stream over WebSocket. Pages from the admin host itself are
+// always allowed.
+func (s *Server) AllowOrigins(origins ...string) {
+	if s.origins == nil {
+		s.origins = make(map[string]bool, len(origins))
+	}
+	for _, o := range origins {
+		s.origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+}
+
+// checkOrigin reports whether r may open a WebSocket. Browsers send Origin on
+// every WebSocket handshake and do not apply the same-origin policy to it, so
+// without this check any page the operator visits could read the stream.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a browser.
+		return true
+	}
+	if s.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request, f filter) {
+	if !s.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	// Clear the deadlines inherited from the HTTP server.
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	ws := &wsSink{w: brw.Writer}
+	go func() {
+		defer cancel()
+		ws.readLoop(brw.Reader)
+	}()
+
+	s.stream(ctx, f, ws)
+	_ = ws.writeFrame(opClose, nil)
+}
+
+type wsSink struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (s *wsSink) send(m message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.writeFrame(opText, data)
+}
+
+func (s *wsSink) ping() error {
+	return s.writeFrame(opPing, nil)
+}
+
+// writeFrame writes a single unmasked, unfragmented frame.
+func (s *wsSink) writeFrame(op byte, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := s.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(payload); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// readLoop consumes client frames until the connection closes or the client
+// sends a close frame.
+func (s *wsSink) readLoop(r *bufio.Reader) {
+	for {
+		op, payload, err := readFrame(r, maxClientPayload)
+		if err != nil {
+			return
+		}
+		switch op {
+		case opClose:
+			return
+		case opPing:
+			if err := s.writeFrame(opPong, payload); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// maxClientPayload bounds what we buffer from a client frame. Control frames
+// never exceed it; larger data frames are discarded without being buffered.
+const maxClientPayload = 125
+
+var errFrameTooLarge = errors.New("websocket: frame too large")
+
+// readFrame reads one frame, unmasking it if needed. Payloads above limit are
+// skipped and returned as nil.
+func readFrame(r *bufio.Reader, limit uint64) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	op := hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7F)
+
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if n > limit {
+		if op >= opClose {
+			return 0, nil, errFrameTooLarge
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return 0, nil, err
+		}
+		return op, nil, nil
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return op, payload, nil
+}
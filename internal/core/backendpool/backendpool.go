@@ -1,7 +1,8 @@
 package backendpool
 
 import (
-	"log"
+	"fmt"
+	"net/netip"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -10,8 +11,31 @@ import (
 	"github.com/diabeney/balto/internal/core"
 	"github.com/diabeney/balto/internal/core/balancer"
 	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
+// Topics published by a Pool. Every event carries a BackendEvent payload.
+const (
+	TopicBackendDown      pubsub.Topic = "backend.down"
+	TopicBackendRecovered pubsub.Topic = "backend.recovered"
+	TopicDrainStarted     pubsub.Topic = "backend.drain.started"
+	TopicDrainFinished    pubsub.Topic = "backend.drain.finished"
+	// TopicDrainTimedOut is published instead of TopicDrainFinished when
+	// the backend still had active connections at the drain deadline.
+	TopicDrainTimedOut pubsub.Topic = "backend.drain.timedout"
+)
+
+type BackendEvent struct {
+	Pool    string `json:"pool"`
+	Backend string `json:"backend"`
+	URL     string `json:"url"`
+	Reason  string `json:"reason"`
+}
+
+func (e BackendEvent) String() string {
+	return fmt.Sprintf("[%s] backend %s (%s): %s", e.Pool, e.Backend, e.URL, e.Reason)
+}
+
 func NewBackend(id string, u *url.URL, weight uint32, cbCfg circuit.Config) *core.Backend {
 	b := &core.Backend{
 		ID:      id,
@@ -31,9 +55,53 @@ type PoolConfig struct {
 	ProbeRecoveryThreshold uint64 // Consecutive successful probes required to mark healthy
 	ProbePath              string
 	ProbeInterval          int
+	ProbeHistorySize       int // probe results kept per backend for debugging
 	Timeout                int
 	Retry                  int
 
+	// Adaptive probe cadence, in milliseconds. ProbeInterval applies while a
+	// backend is healthy.
+	ProbeUnhealthyInterval int // while unhealthy; defaults to ProbeInterval
+	ProbeRecoveryInterval  int // optional faster cadence while unhealthy or the circuit is Open
+	ProbeBackoffAfter      int // once down this long, probes back off exponentially; 0 disables
+	ProbeMaxInterval       int // upper bound for back-off; defaults to 60s
+
+	// ProbeType selects the probe: "http", "tcp", "tls" or "grpc". Empty
+	// picks one from the backend URL scheme (http/https -> http,
+	// grpc/grpcs -> grpc, anything else -> tcp).
+	ProbeType string
+
+	// TCP and TLS probes: optional payload written after connecting and the
+	// reply expected back within the probe timeout.
+	ProbeSend        string
+	ProbeExpect      string // prefix the reply must start with
+	ProbeExpectRegex string // regular expression the reply must match
+
+	// TLS probe: fail when the leaf certificate expires within this many days.
+	ProbeTLSMinValidDays int
+
+	// gRPC probe: service name sent in grpc.health.v1.HealthCheckRequest.
+	// Empty checks the overall server health.
+	ProbeGRPCService string
+
+	// TLS settings for probes that use TLS (https, grpcs). They are read when
+	// the health checker is created.
+	ProbeTLSServerName         string
+	ProbeTLSInsecureSkipVerify bool
+
+	// HTTP probe request
+	ProbeMethod  string            // GET (default), HEAD or POST
+	ProbeHost    string            // overrides the Host header sent to the backend
+	ProbeHeaders map[string]string // extra headers, e.g. Authorization
+	ProbeBody    string            // request body, only sent with POST
+
+	// HTTP probe expectations. All configured expectations must hold.
+	ProbeExpectStatus    string // status codes and ranges, e.g. "200-299,301". Defaults to 200-399
+	ProbeExpectBody      string // substring the response body must contain
+	ProbeExpectBodyRegex string // regular expression the response body must match
+	ProbeExpectJSONField string // dotted path into a JSON body, e.g. "status" or "checks.db.status"
+	ProbeExpectJSONValue string // value the JSON field must equal, e.g. "UP"
+
 	// Circuit Breaker Config
 	CircuitFailureThreshold    uint64
 	CircuitSuccessThreshold    uint64
@@ -49,6 +117,7 @@ type Pool struct {
 	backends atomic.Pointer[BackendList]
 	balancer balancer.Balancer
 	config   atomic.Pointer[PoolConfig]
+	bus      atomic.Pointer[pubsub.Bus]
 
 	// For operations that require scanning and updates we still use a small mutex
 	opMu sync.Mutex
@@ -60,6 +129,7 @@ func New(poolCfg *PoolConfig, bal balancer.Balancer) *Pool {
 	}
 	//TODO: Validate the required fields
 	p.config.Store(poolCfg)
+	p.bus.Store(pubsub.Default())
 	p.backends.Store(&BackendList{Items: []*core.Backend{}})
 
 	if p.balancer != nil {
@@ -104,6 +174,9 @@ func (p *Pool) Add(id string, u *url.URL, weight uint32) {
 		SuccessThreshold:    cfg.CircuitSuccessThreshold,
 		Timeout:             time.Duration(cfg.CircuitTimeout) * time.Second,
 		MaxHalfOpenRequests: cfg.CircuitMaxHalfOpenRequests,
+		Pool:                cfg.ServiceName,
+		Name:                id,
+		Bus:                 p.Bus(),
 	}
 
 	newB := NewBackend(id, u, weight, cbCfg)
@@ -154,11 +227,42 @@ func (p *Pool) Config() *PoolConfig {
 	return &out
 }
 
+// SetBus replaces the bus the pool and the breakers of backends added
+// afterwards publish on.
+func (p *Pool) SetBus(b *pubsub.Bus) {
+	if b == nil {
+		b = pubsub.Default()
+	}
+	p.bus.Store(b)
+}
+
+func (p *Pool) Bus() *pubsub.Bus {
+	return p.bus.Load()
+}
+
+func (p *Pool) publish(topic pubsub.Topic, b *core.Backend, reason string) {
+	ev := BackendEvent{
+		Pool:    p.Config().ServiceName,
+		Backend: b.ID,
+		Reason:  reason,
+	}
+	if b.URL != nil {
+		ev.URL = b.URL.String()
+	}
+	p.Bus().Publish(topic, ev)
+}
+
 func (p *Pool) Balancer() balancer.Balancer {
 	return p.balancer
 }
 
 func (p *Pool) Next() *core.Backend {
+	return p.NextFor(netip.Addr{})
+}
+
+// NextFor is Next for a request from client. Balancers implementing
+// balancer.ClientBalancer get the client IP when it is valid.
+func (p *Pool) NextFor(client netip.Addr) *core.Backend {
 	if p.balancer == nil {
 		return nil
 	}
@@ -179,6 +283,9 @@ func (p *Pool) Next() *core.Backend {
 	if len(candidates) == 0 {
 		return nil
 	}
+	if cb, ok := p.balancer.(balancer.ClientBalancer); ok && client.IsValid() {
+		return cb.NextFor(candidates, client)
+	}
 	return p.balancer.Next(candidates)
 }
 
@@ -215,7 +322,7 @@ func (p *Pool) RecordFailure(b *core.Backend) {
 	}
 	if b.Meta.PassiveFailCount.Load() >= threshold {
 		if b.SetHealthy(false) {
-			log.Printf("BackendDown: (%s) is now unhealthy (threshold reached)", b.URL)
+			p.publish(TopicBackendDown, b, "passive failure threshold reached")
 		}
 	}
 }
@@ -234,7 +341,7 @@ func (p *Pool) CheckHealth(b *core.Backend) {
 	probeThreshold := probeThreshold(cfg)
 	if passive >= passiveThreshold || probe >= probeThreshold {
 		if b.SetHealthy(false) {
-			log.Printf("BackendDown: (%s) is now unhealthy (check health)", b.URL)
+			p.publish(TopicBackendDown, b, "health check threshold reached")
 		}
 	}
 }
@@ -245,7 +352,7 @@ func (p *Pool) ResetHealth(b *core.Backend) {
 	}
 	b.Meta.ResetAllFailCounts()
 	if b.SetHealthy(true) {
-		log.Printf("BackendRecovered: (%s) health reset manually", b.URL)
+		p.publish(TopicBackendRecovered, b, "health reset manually")
 	}
 }
 
@@ -277,7 +384,7 @@ func (p *Pool) MarkHealthy(b *core.Backend) {
 	if b.Meta.ProbeSuccessCount.Load() >= recoveryThreshold {
 		if !b.IsHealthy() {
 			if b.SetHealthy(true) {
-				log.Printf("BackendRecovered: (%s) marked healthy by Probe", b.URL)
+				p.publish(TopicBackendRecovered, b, "marked healthy by probe")
 			}
 		}
 	}
@@ -301,7 +408,7 @@ func (p *Pool) MarkUnhealthy(b *core.Backend) {
 	}
 	if b.Meta.ProbeFailCount.Load() >= probeThreshold(cfg) {
 		if b.SetHealthy(false) {
-			log.Printf("BackendDown: (%s) marked unhealthy by probe", b.URL)
+			p.publish(TopicBackendDown, b, "marked unhealthy by probe")
 		}
 	}
 }
@@ -316,6 +423,7 @@ func (p *Pool) StartDraining(id string) {
 			//SetDraining is internally protected by atomics,
 			// so this single-field update is safe.
 			b.SetDraining(true)
+			p.publish(TopicDrainStarted, b, "drain started")
 			return
 		}
 	}
@@ -327,29 +435,35 @@ func (p *Pool) WaitForDrain(id string, timeout time.Duration) bool {
 	// because that would stall all Add/Remove operations for the entire timeout duration.
 	// We only need it just for the brief moment we retrieve the list
 	deadline := time.Now().Add(timeout)
+	var last *core.Backend
 	for time.Now().Before(deadline) {
 		p.opMu.Lock()
 		items := p.List()
 
-		found := false
+		var draining *core.Backend
 		for _, b := range items {
 			if b.ID == id && b.IsDraining() {
-				found = true
+				draining = b
 				if b.Meta.Active() == 0 {
 					// Release the lock immediately upon success
 					p.opMu.Unlock()
+					p.publish(TopicDrainFinished, b, "drained")
 					return true
 				}
 			}
 		}
 		p.opMu.Unlock()
 
-		if !found {
+		if draining == nil {
 			return false
 		}
+		last = draining
 		//TODO: Make this configurable
 		time.Sleep(50 * time.Millisecond)
 	}
+	if last != nil {
+		p.publish(TopicDrainTimedOut, last, "drain timed out")
+	}
 	return false
 }
 
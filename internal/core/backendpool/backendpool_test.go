@@ -1,13 +1,16 @@
 package backendpool
 
 import (
+	"net/netip"
 	"net/url"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/diabeney/balto/internal/core"
 	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
 type mockBalancer struct {
@@ -33,6 +36,34 @@ func (m *mockBalancer) Update(backends []*core.Backend) {
 	m.mu.Unlock()
 }
 
+// clientBalancer picks the last candidate for clients and the first otherwise.
+type clientBalancer struct {
+	mockBalancer
+	client netip.Addr
+}
+
+func (c *clientBalancer) NextFor(backends []*core.Backend, client netip.Addr) *core.Backend {
+	c.client = client
+	return backends[len(backends)-1]
+}
+
+func TestPoolNextFor(t *testing.T) {
+	bal := &clientBalancer{}
+	p := New(&PoolConfig{}, bal)
+	u1, _ := url.Parse("http://a")
+	u2, _ := url.Parse("http://b")
+	p.Add("a", u1, 1)
+	p.Add("b", u2, 1)
+
+	if b := p.Next(); b == nil || b.ID != "a" || bal.client.IsValid() {
+		t.Errorf("Next without a client should use Next, got %v", b)
+	}
+	client := netip.MustParseAddr("203.0.113.9")
+	if b := p.NextFor(client); b == nil || b.ID != "b" || bal.client != client {
+		t.Errorf("NextFor should pass the client to the balancer, got %v for %v", b, bal.client)
+	}
+}
+
 func TestPoolNew(t *testing.T) {
 	cfg := &PoolConfig{ServiceName: "test", HealthThreshold: 3, ProbeHealthThreshold: 2, CircuitMaxHalfOpenRequests: 2}
 	bal := &mockBalancer{}
@@ -248,3 +279,65 @@ func TestProbeRecoveryThreshold(t *testing.T) {
 		t.Fatalf("probe success count should reset to 0 after failure, got %d", b.Meta.ProbeSuccessCount.Load())
 	}
 }
+
+func TestPoolPublishesEvents(t *testing.T) {
+	bus := pubsub.New()
+	sub := bus.Subscribe(16)
+	defer sub.Close()
+
+	p := New(&PoolConfig{ServiceName: "events", HealthThreshold: 1, ProbeRecoveryThreshold: 1}, &mockBalancer{})
+	p.SetBus(bus)
+	u, _ := url.Parse("http://events")
+	p.Add("e1", u, 1)
+	b := p.List()[0]
+
+	p.MarkUnhealthy(b)
+	p.MarkHealthy(b)
+	p.StartDraining("e1")
+	p.WaitForDrain("e1", time.Second)
+
+	want := []pubsub.Topic{
+		TopicBackendDown,
+		TopicBackendRecovered,
+		TopicDrainStarted,
+		TopicDrainFinished,
+	}
+	var got []pubsub.Topic
+	for len(sub.C()) > 0 {
+		ev := <-sub.C()
+		if e, ok := pubsub.As[BackendEvent](ev); ok {
+			if e.Pool != "events" || e.Backend != "e1" {
+				t.Errorf("unexpected payload %+v", e)
+			}
+			got = append(got, ev.Topic)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected topics %v, got %v", want, got)
+	}
+}
+
+func TestPoolPublishesDrainTimeout(t *testing.T) {
+	bus := pubsub.New()
+	sub := bus.Subscribe(16, TopicDrainFinished, TopicDrainTimedOut)
+	defer sub.Close()
+
+	p := New(&PoolConfig{ServiceName: "events"}, &mockBalancer{})
+	p.SetBus(bus)
+	u, _ := url.Parse("http://events")
+	p.Add("e1", u, 1)
+	p.List()[0].Meta.IncrActive()
+
+	p.StartDraining("e1")
+	if p.WaitForDrain("e1", 60*time.Millisecond) {
+		t.Fatal("drain with an active connection should time out")
+	}
+	select {
+	case ev := <-sub.C():
+		if ev.Topic != TopicDrainTimedOut {
+			t.Errorf("expected %s, got %s", TopicDrainTimedOut, ev.Topic)
+		}
+	default:
+		t.Error("no drain event published")
+	}
+}
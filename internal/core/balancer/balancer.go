@@ -1,6 +1,10 @@
 package balancer
 
-import "github.com/diabeney/balto/internal/core"
+import (
+	"net/netip"
+
+	"github.com/diabeney/balto/internal/core"
+)
 
 // Balancer defines a generic load balancing strategy.
 type Balancer interface {
@@ -10,3 +14,13 @@ type Balancer interface {
 	// Update is called when the pool changes (add/remove).
 	Update([]*core.Backend)
 }
+
+// ClientBalancer is implemented by balancers that take the client into
+// account, e.g. to keep a client on the same backend. Pools call NextFor
+// instead of Next when the client IP is known.
+type ClientBalancer interface {
+	Balancer
+
+	// NextFor selects a backend from the candidates for client.
+	NextFor(candidates []*core.Backend, client netip.Addr) *core.Backend
+}
@@ -1,11 +1,31 @@
 package circuit
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
+// TopicStateChange carries a StateChange every time a breaker transitions.
+const TopicStateChange pubsub.Topic = "circuit.state"
+
+type StateChange struct {
+	Pool string `json:"pool,omitempty"`
+	Name string `json:"name"`
+	From State  `json:"from"`
+	To   State  `json:"to"`
+}
+
+func (c StateChange) String() string {
+	if c.Pool != "" {
+		return fmt.Sprintf("[%s] circuit (%s) %s -> %s", c.Pool, c.Name, c.From, c.To)
+	}
+	return fmt.Sprintf("circuit (%s) %s -> %s", c.Name, c.From, c.To)
+}
+
 type State uint32
 
 const (
@@ -27,11 +47,34 @@ func (s State) String() string {
 	}
 }
 
+// MarshalText encodes the state by name so it reads well in JSON payloads.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "Closed":
+		*s = Closed
+	case "Open":
+		*s = Open
+	case "Half-Open":
+		*s = HalfOpen
+	default:
+		return fmt.Errorf("unknown circuit state %q", text)
+	}
+	return nil
+}
+
 type Config struct {
 	FailureThreshold    uint64        // consecutive failures before opening the breaker
 	SuccessThreshold    uint64        // consecutive successes in Half-Open required to close
 	Timeout             time.Duration // base Open timeout before allowing Half-Open
 	MaxHalfOpenRequests uint32        // bounds the number of concurrent trial requests while Half-Open.
+
+	Pool string      // the pool the breaker's backend belongs to, if any
+	Name string      // identifies the breaker in published state changes
+	Bus  *pubsub.Bus // defaults to pubsub.Default()
 }
 
 type Breaker struct {
@@ -62,6 +105,9 @@ func New(cfg Config) *Breaker {
 	if cfg.MaxHalfOpenRequests == 0 {
 		cfg.MaxHalfOpenRequests = 3
 	}
+	if cfg.Bus == nil {
+		cfg.Bus = pubsub.Default()
+	}
 
 	b := &Breaker{
 		cfg: cfg,
@@ -143,7 +189,7 @@ func (b *Breaker) RecordProbeSuccess() {
 	if s == Open {
 		// A successful probe transitions the breaker to Half-Open
 		// and clears counters to begin the recovery phase.
-		b.transitionToHalfOpenLocked()
+		b.transitionToHalfOpenLocked(Open)
 	}
 }
 
@@ -171,29 +217,43 @@ func (b *Breaker) RecordFailure() {
 }
 
 func (b *Breaker) transitionToOpenLocked() {
+	from := State(b.state.Load())
 	b.state.Store(uint32(Open))
 	b.openTime.Store(time.Now().UnixNano())
 	b.successes = 0
 	b.failures = 0
 	b.halfOpenInFlight.Store(0)
 	b.adjustOpenTimeoutLocked(increaseTimeout)
+	b.publishLocked(from, Open)
 }
 
-func (b *Breaker) transitionToHalfOpenLocked() {
+func (b *Breaker) transitionToHalfOpenLocked(from State) {
 	b.state.Store(uint32(HalfOpen))
 	b.openTime.Store(0)
 	b.failures = 0
 	b.successes = 0
 	b.halfOpenInFlight.Store(0)
 	b.adjustOpenTimeoutLocked(resetTimeout)
+	b.publishLocked(from, HalfOpen)
 }
 
 func (b *Breaker) transitionToClosedLocked() {
+	from := State(b.state.Load())
 	b.state.Store(uint32(Closed))
 	b.failures = 0
 	b.successes = 0
 	b.halfOpenInFlight.Store(0)
 	b.adjustOpenTimeoutLocked(resetTimeout)
+	b.publishLocked(from, Closed)
+}
+
+// publishLocked announces a transition. Publishing is non-blocking, so it is
+// safe to call while holding the cold path mutex.
+func (b *Breaker) publishLocked(from, to State) {
+	if from == to {
+		return
+	}
+	b.cfg.Bus.Publish(TopicStateChange, StateChange{Pool: b.cfg.Pool, Name: b.cfg.Name, From: from, To: to})
 }
 
 // tryAcquireHalfOpenSlot attempts to atomically increment the in-flight counter.
@@ -235,7 +295,7 @@ func (b *Breaker) checkAndTransitionOpen() bool {
 
 		// Goroutine that won the CAS is responsible for cold path cleanup.
 		b.mu.Lock()
-		b.transitionToHalfOpenLocked()
+		b.transitionToHalfOpenLocked(Open)
 		b.mu.Unlock()
 
 		// The winning goroutine must then acquire a slot to ensure its subsequent
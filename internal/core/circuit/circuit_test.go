@@ -3,6 +3,8 @@ package circuit
 import (
 	"testing"
 	"time"
+
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
 func TestBreakerTransitions(t *testing.T) {
@@ -193,3 +195,27 @@ func TestBreakerOpenTimeoutBackoff(t *testing.T) {
 		t.Fatalf("expected timeout reset to %v, got %v", cfg.Timeout, reset)
 	}
 }
+
+func TestBreakerPublishesStateChanges(t *testing.T) {
+	bus := pubsub.New()
+	sub := bus.Subscribe(8, TopicStateChange)
+	defer sub.Close()
+
+	cb := New(Config{FailureThreshold: 1, SuccessThreshold: 1, Name: "b1", Bus: bus})
+	cb.RecordFailure()
+	cb.RecordProbeSuccess()
+	cb.RecordProbeSuccess()
+
+	want := []StateChange{
+		{Name: "b1", From: Closed, To: Open},
+		{Name: "b1", From: Open, To: HalfOpen},
+		{Name: "b1", From: HalfOpen, To: Closed},
+	}
+	for _, w := range want {
+		ev := <-sub.C()
+		got, ok := pubsub.As[StateChange](ev)
+		if !ok || got != w {
+			t.Errorf("expected %v, got %v", w, ev.Data)
+		}
+	}
+}
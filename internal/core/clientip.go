@@ -0,0 +1,21 @@
+package core
+
+import (
+	"context"
+	"net/netip"
+)
+
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of ctx carrying the IP of the client a request
+// is served for, as derived by the proxy from the connection and any trusted
+// forwarding headers.
+func WithClientIP(ctx context.Context, ip netip.Addr) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIP returns the client IP stored by WithClientIP.
+func ClientIP(ctx context.Context) (netip.Addr, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(netip.Addr)
+	return ip, ok && ip.IsValid()
+}
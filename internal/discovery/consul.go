@@ -0,0 +1,222 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+const (
+	DefaultConsulAddress   = "http://127.0.0.1:8500"
+	DefaultConsulWait      = 5 * time.Minute
+	DefaultConsulRetry     = 5 * time.Second
+	DefaultConsulMinDelay  = time.Second
+	DefaultConsulWeightTag = "weight="
+)
+
+type ConsulConfig struct {
+	Address    string // catalog HTTP API, DefaultConsulAddress when empty
+	Service    string
+	Tag        string // only instances with this tag; all when empty
+	Datacenter string
+	Token      string // sent as X-Consul-Token
+
+	// WeightTag is the tag prefix carrying an instance's weight, e.g.
+	// "weight=3". Instances without one use their passing weight.
+	WeightTag string
+	Scheme    string // scheme of the backend URLs, "http" when empty
+
+	Wait          time.Duration // blocking query wait, DefaultConsulWait when zero
+	RetryInterval time.Duration // wait after errors, DefaultConsulRetry when zero
+	MinDelay      time.Duration // least time between queries, DefaultConsulMinDelay when zero
+	DrainTimeout  time.Duration // see NewSyncer
+	Bus           *pubsub.Bus   // receives ErrorEvents, pubsub.Default() when nil
+}
+
+// Consul long-polls a Consul-compatible health API and reconciles the
+// passing instances of a service into a pool.
+type Consul struct {
+	cfg    ConsulConfig
+	client *http.Client
+	syncer *Syncer
+}
+
+// consulEntry is the subset of a /v1/health/service entry used.
+type consulEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+		Tags    []string `json:"Tags"`
+		Weights struct {
+			Passing int `json:"Passing"`
+		} `json:"Weights"`
+	} `json:"Service"`
+}
+
+func NewConsul(cfg ConsulConfig, pool *backendpool.Pool) (*Consul, error) {
+	if cfg.Service == "" {
+		return nil, errors.New("consul discovery: service is required")
+	}
+	if cfg.Address == "" {
+		cfg.Address = DefaultConsulAddress
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	if cfg.WeightTag == "" {
+		cfg.WeightTag = DefaultConsulWeightTag
+	}
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = DefaultConsulWait
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultConsulRetry
+	}
+	if cfg.MinDelay <= 0 {
+		cfg.MinDelay = DefaultConsulMinDelay
+	}
+	return &Consul{
+		cfg: cfg,
+		// Consul adds up to wait/16 of jitter to blocking queries.
+		client: &http.Client{Timeout: cfg.Wait + cfg.Wait/16 + 10*time.Second},
+		syncer: NewSyncer(pool, cfg.DrainTimeout),
+	}, nil
+}
+
+// Run long-polls until ctx is done. A failed query leaves the pool unchanged.
+// Queries that return early, e.g. from an agent that does not block, are
+// spaced MinDelay apart.
+func (c *Consul) Run(ctx context.Context) {
+	var index uint64
+	for {
+		start := time.Now()
+		next, err := c.Refresh(ctx, index)
+		if ctx.Err() != nil {
+			c.syncer.Wait()
+			return
+		}
+		if err != nil {
+			publishError(c.cfg.Bus, "consul", c.syncer.Pool().Config().ServiceName, err)
+			index = 0
+			select {
+			case <-ctx.Done():
+				c.syncer.Wait()
+				return
+			case <-time.After(c.cfg.RetryInterval):
+			}
+			continue
+		}
+		index = next
+		if wait := c.cfg.MinDelay - time.Since(start); wait > 0 {
+			select {
+			case <-ctx.Done():
+				c.syncer.Wait()
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// Refresh runs one query, blocking while the catalog is still at index, and
+// applies the result. It returns the index to block on next.
+func (c *Consul) Refresh(ctx context.Context, index uint64) (uint64, error) {
+	targets, next, err := c.query(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+	c.syncer.Apply(targets)
+
+	// Indexes must only grow; anything else means the catalog was reset.
+	// Start over from 1 rather than 0, which would not block, as for a
+	// missing index.
+	if next < index || next == 0 {
+		next = 1
+	}
+	return next, nil
+}
+
+func (c *Consul) query(ctx context.Context, index uint64) ([]Target, uint64, error) {
+	q := url.Values{"passing": {"1"}}
+	if c.cfg.Tag != "" {
+		q.Set("tag", c.cfg.Tag)
+	}
+	if c.cfg.Datacenter != "" {
+		q.Set("dc", c.cfg.Datacenter)
+	}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", fmt.Sprintf("%ds", int(c.cfg.Wait.Seconds())))
+	}
+
+	u := c.cfg.Address + "/v1/health/service/" + url.PathEscape(c.cfg.Service) + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("querying service %s: %w", c.cfg.Service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("querying service %s: unexpected status %d", c.cfg.Service, resp.StatusCode)
+	}
+
+	var entries []consulEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("querying service %s: %w", c.cfg.Service, err)
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+
+	targets := make([]Target, 0, len(entries))
+	for _, e := range entries {
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		if addr == "" || e.Service.Port <= 0 {
+			continue
+		}
+		targets = append(targets, Target{
+			ID:     e.Node.Node + "/" + e.Service.ID,
+			URL:    &url.URL{Scheme: c.cfg.Scheme, Host: net.JoinHostPort(addr, strconv.Itoa(e.Service.Port))},
+			Weight: c.weight(e),
+		})
+	}
+	return sortTargets(targets), next, nil
+}
+
+func (c *Consul) weight(e consulEntry) uint32 {
+	for _, tag := range e.Service.Tags {
+		if v, ok := strings.CutPrefix(tag, c.cfg.WeightTag); ok {
+			if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+				return uint32(n)
+			}
+		}
+	}
+	if e.Service.Weights.Passing > 0 {
+		return uint32(e.Service.Weights.Passing)
+	}
+	return 1
+}
@@ -0,0 +1,191 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCatalog answers /v1/health/service queries, blocking while the
+// requested index is current.
+type fakeCatalog struct {
+	mu      sync.Mutex
+	index   uint64
+	entries []map[string]any
+	changed chan struct{}
+}
+
+func newFakeCatalog(t *testing.T) (*fakeCatalog, string) {
+	t.Helper()
+	fc := &fakeCatalog{index: 1, changed: make(chan struct{})}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" || r.URL.Query().Get("passing") != "1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("X-Consul-Token") != "tok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		idx, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+		fc.mu.Lock()
+		if idx == fc.index {
+			changed := fc.changed
+			fc.mu.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			fc.mu.Lock()
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(fc.index, 10))
+		_ = json.NewEncoder(w).Encode(fc.entries)
+		fc.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return fc, srv.URL
+}
+
+func (fc *fakeCatalog) set(entries ...map[string]any) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.entries = entries
+	fc.index++
+	close(fc.changed)
+	fc.changed = make(chan struct{})
+}
+
+func consulInstance(node, addr string, port int, tags ...string) map[string]any {
+	return map[string]any{
+		"Node":    map[string]any{"Node": node, "Address": addr},
+		"Service": map[string]any{"ID": "web-" + node, "Port": port, "Tags": tags, "Weights": map[string]any{"Passing": 1}},
+	}
+}
+
+func TestConsulBlockingQueries(t *testing.T) {
+	fc, addr := newFakeCatalog(t)
+	fc.set(
+		consulInstance("n1", "10.2.0.1", 8080, "weight=4"),
+		consulInstance("n2", "10.2.0.2", 8080),
+	)
+
+	pool := newTestPool()
+	c, err := NewConsul(ConsulConfig{Address: addr, Service: "web", Token: "tok", DrainTimeout: 50 * time.Millisecond}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := c.Refresh(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 2 {
+		t.Errorf("expected index 2, got %d", index)
+	}
+	if got := poolState(pool); !equal(got, []string{"n1/web-n1", "n2/web-n2"}) {
+		t.Fatalf("unexpected backends %v", got)
+	}
+	for _, b := range pool.List() {
+		if b.ID == "n1/web-n1" && (b.Weight != 4 || b.URL.String() != "http://10.2.0.1:8080") {
+			t.Errorf("unexpected backend %s weight %d", b.URL, b.Weight)
+		}
+	}
+
+	t.Run("Blocks until the catalog changes", func(t *testing.T) {
+		done := make(chan uint64)
+		go func() {
+			next, err := c.Refresh(context.Background(), index)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- next
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("query returned before the catalog changed")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		fc.set(consulInstance("n2", "10.2.0.2", 8080))
+		select {
+		case next := <-done:
+			if next != 3 {
+				t.Errorf("expected index 3, got %d", next)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("query did not return after change")
+		}
+		c.syncer.Wait()
+		if got := poolState(pool); !equal(got, []string{"n2/web-n2"}) {
+			t.Errorf("unexpected backends %v", got)
+		}
+	})
+}
+
+func TestConsulWithoutIndex(t *testing.T) {
+	var mu sync.Mutex
+	var indexes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		indexes = append(indexes, r.URL.Query().Get("index"))
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode([]map[string]any{consulInstance("n1", "10.2.0.1", 8080)})
+	}))
+	defer srv.Close()
+
+	pool := newTestPool()
+	c, err := NewConsul(ConsulConfig{Address: srv.URL, Service: "web", MinDelay: 50 * time.Millisecond}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, err := c.Refresh(context.Background(), 0); err != nil || next != 1 {
+		t.Fatalf("expected index 1 without X-Consul-Index, got %d (err %v)", next, err)
+	}
+	if next, _ := c.Refresh(context.Background(), 5); next != 1 {
+		t.Errorf("expected index reset to 1, got %d", next)
+	}
+
+	mu.Lock()
+	indexes = nil
+	mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	c.Run(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(indexes) < 2 || len(indexes) > 8 {
+		t.Fatalf("expected queries spaced by the minimum delay, got %d", len(indexes))
+	}
+	if indexes[0] != "" || indexes[1] != "1" {
+		t.Errorf("expected a first query without index, then index 1, got %v", indexes)
+	}
+}
+
+func TestConsulWeight(t *testing.T) {
+	c, err := NewConsul(ConsulConfig{Service: "web"}, newTestPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e consulEntry
+	e.Service.Tags = []string{"primary", "weight=x", "weight=7"}
+	if w := c.weight(e); w != 7 {
+		t.Errorf("expected weight from tag, got %d", w)
+	}
+	e.Service.Tags = nil
+	e.Service.Weights.Passing = 3
+	if w := c.weight(e); w != 3 {
+		t.Errorf("expected passing weight, got %d", w)
+	}
+	if _, err := NewConsul(ConsulConfig{}, newTestPool()); err == nil {
+		t.Error("expected error without service")
+	}
+}
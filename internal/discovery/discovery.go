@@ -0,0 +1,180 @@
+// Package discovery keeps backend pools in sync with external sources of
+// backends such as DNS.
+package discovery
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+// TopicError carries an ErrorEvent whenever a provider fails to refresh. The
+// pools keep their last known backends until the next successful refresh.
+const TopicError pubsub.Topic = "discovery.error"
+
+type ErrorEvent struct {
+	Source string `json:"source"` // provider, e.g. "dns"
+	Pool   string `json:"pool,omitempty"`
+	Error  string `json:"error"`
+}
+
+func (e ErrorEvent) String() string {
+	if e.Pool == "" {
+		return fmt.Sprintf("%s discovery: %s", e.Source, e.Error)
+	}
+	return fmt.Sprintf("[%s] %s discovery: %s", e.Pool, e.Source, e.Error)
+}
+
+// DefaultDrainTimeout bounds how long a backend that disappeared from its
+// source keeps serving in-flight requests before it is removed.
+const DefaultDrainTimeout = 30 * time.Second
+
+// Target is a backend as reported by a discovery source.
+type Target struct {
+	ID       string // stable identity within the pool; defaults to the URL
+	URL      *url.URL
+	Weight   uint32 // zero means 1
+	Draining bool   // still present but should receive no new requests
+}
+
+func (t Target) id() string {
+	if t.ID != "" {
+		return t.ID
+	}
+	return t.URL.String()
+}
+
+func (t Target) weight() uint32 {
+	if t.Weight == 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+// Syncer applies target sets to a pool: new targets are added, changed ones
+// replaced and vanished ones drained, then removed.
+type Syncer struct {
+	pool         *backendpool.Pool
+	drainTimeout time.Duration
+
+	mu       sync.Mutex
+	removing map[string]chan struct{} // closed to cancel a pending removal
+	wg       sync.WaitGroup
+}
+
+func NewSyncer(pool *backendpool.Pool, drainTimeout time.Duration) *Syncer {
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultDrainTimeout
+	}
+	return &Syncer{
+		pool:         pool,
+		drainTimeout: drainTimeout,
+		removing:     make(map[string]chan struct{}),
+	}
+}
+
+func (s *Syncer) Pool() *backendpool.Pool {
+	return s.pool
+}
+
+// Apply makes targets the pool's backend set.
+func (s *Syncer) Apply(targets []Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	want := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		if t.URL != nil {
+			want[t.id()] = t
+		}
+	}
+
+	for _, b := range s.pool.List() {
+		t, ok := want[b.ID]
+		if !ok {
+			s.removeLocked(b.ID)
+			continue
+		}
+		delete(want, b.ID)
+
+		if b.URL.String() != t.URL.String() || b.Weight != t.weight() {
+			s.cancelRemovalLocked(b.ID)
+			s.pool.Remove(b.ID)
+			s.addLocked(t)
+			continue
+		}
+
+		if cancel, pending := s.removing[b.ID]; pending {
+			close(cancel)
+			delete(s.removing, b.ID)
+		} else if t.Draining == b.IsDraining() {
+			continue
+		}
+		if t.Draining {
+			s.pool.StartDraining(b.ID)
+		} else {
+			b.SetDraining(false)
+		}
+	}
+
+	for _, t := range want {
+		s.addLocked(t)
+	}
+}
+
+func (s *Syncer) addLocked(t Target) {
+	id := t.id()
+	s.pool.Add(id, t.URL, t.weight())
+	if t.Draining {
+		s.pool.StartDraining(id)
+	}
+}
+
+func (s *Syncer) removeLocked(id string) {
+	if _, pending := s.removing[id]; pending {
+		return
+	}
+	cancel := make(chan struct{})
+	s.removing[id] = cancel
+	s.pool.StartDraining(id)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.pool.WaitForDrain(id, s.drainTimeout)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-cancel:
+			// The target came back while draining.
+			return
+		default:
+		}
+		delete(s.removing, id)
+		s.pool.Remove(id)
+	}()
+}
+
+func (s *Syncer) cancelRemovalLocked(id string) {
+	if cancel, pending := s.removing[id]; pending {
+		close(cancel)
+		delete(s.removing, id)
+	}
+}
+
+// Wait blocks until pending removals have finished.
+func (s *Syncer) Wait() {
+	s.wg.Wait()
+}
+
+func publishError(bus *pubsub.Bus, source, pool string, err error) {
+	if bus == nil {
+		bus = pubsub.Default()
+	}
+	bus.Publish(TopicError, ErrorEvent{Source: source, Pool: pool, Error: err.Error()})
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/core/balancer"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+func newTestPool() *backendpool.Pool {
+	pool := backendpool.New(&backendpool.PoolConfig{ServiceName: "svc"}, balancer.NewRoundRobin())
+	pool.SetBus(pubsub.New())
+	return pool
+}
+
+func target(raw string, weight uint32) Target {
+	u, _ := url.Parse(raw)
+	return Target{URL: u, Weight: weight}
+}
+
+func poolState(pool *backendpool.Pool) []string {
+	var out []string
+	for _, b := range pool.List() {
+		s := b.ID
+		if b.IsDraining() {
+			s += " (draining)"
+		}
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSyncerApply(t *testing.T) {
+	pool := newTestPool()
+	s := NewSyncer(pool, time.Second)
+
+	s.Apply([]Target{target("http://10.0.0.1:80", 1), target("http://10.0.0.2:80", 2)})
+	if got := poolState(pool); !equal(got, []string{"http://10.0.0.1:80", "http://10.0.0.2:80"}) {
+		t.Fatalf("after add: %v", got)
+	}
+
+	t.Run("Weight change replaces the backend", func(t *testing.T) {
+		s.Apply([]Target{target("http://10.0.0.1:80", 1), target("http://10.0.0.2:80", 5)})
+		for _, b := range pool.List() {
+			if b.ID == "http://10.0.0.2:80" && b.Weight != 5 {
+				t.Errorf("expected weight 5, got %d", b.Weight)
+			}
+		}
+	})
+
+	t.Run("Vanished targets drain then go", func(t *testing.T) {
+		s.Apply([]Target{target("http://10.0.0.1:80", 1)})
+		if got := poolState(pool); !equal(got, []string{"http://10.0.0.1:80", "http://10.0.0.2:80 (draining)"}) {
+			t.Fatalf("expected draining backend, got %v", got)
+		}
+		s.Wait()
+		if got := poolState(pool); !equal(got, []string{"http://10.0.0.1:80"}) {
+			t.Errorf("expected removal after drain, got %v", got)
+		}
+	})
+
+	t.Run("Returning target cancels removal", func(t *testing.T) {
+		b := pool.List()[0]
+		b.Meta.IncrActive() // keeps the drain from finishing
+
+		s.Apply(nil)
+		if !b.IsDraining() {
+			t.Fatal("expected backend to drain")
+		}
+		s.Apply([]Target{target("http://10.0.0.1:80", 1)})
+		s.Wait()
+		if got := poolState(pool); !equal(got, []string{"http://10.0.0.1:80"}) {
+			t.Errorf("expected backend kept and serving, got %v", got)
+		}
+		b.Meta.DecrActive()
+	})
+
+	t.Run("Draining targets", func(t *testing.T) {
+		tg := target("http://10.0.0.1:80", 1)
+		tg.Draining = true
+		s.Apply([]Target{tg})
+		if got := poolState(pool); !equal(got, []string{"http://10.0.0.1:80 (draining)"}) {
+			t.Errorf("expected draining backend, got %v", got)
+		}
+		tg.Draining = false
+		s.Apply([]Target{tg})
+		if got := poolState(pool); !equal(got, []string{"http://10.0.0.1:80"}) {
+			t.Errorf("expected serving backend, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+const DefaultDNSInterval = 30 * time.Second
+
+// Resolver is the subset of *net.Resolver the DNS provider uses, so tests can
+// substitute canned answers.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+type DNSConfig struct {
+	// Name is the hostname whose A/AAAA records are used, or with SRV the
+	// full record name, e.g. "_http._tcp.api.service.internal".
+	Name   string
+	SRV    bool
+	Port   int    // backend port for A/AAAA records; SRV records carry their own
+	Scheme string // scheme of the backend URLs, "http" when empty
+
+	Interval     time.Duration // re-resolution interval, DefaultDNSInterval when zero
+	DrainTimeout time.Duration // see NewSyncer
+	Resolver     Resolver      // net.DefaultResolver when nil
+	Bus          *pubsub.Bus   // receives ErrorEvents, pubsub.Default() when nil
+}
+
+// DNS periodically resolves a name and reconciles the answer into a pool.
+type DNS struct {
+	cfg    DNSConfig
+	syncer *Syncer
+}
+
+func NewDNS(cfg DNSConfig, pool *backendpool.Pool) (*DNS, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("dns discovery: name is required")
+	}
+	if !cfg.SRV && (cfg.Port <= 0 || cfg.Port > 65535) {
+		return nil, fmt.Errorf("dns discovery: invalid port %d for %s", cfg.Port, cfg.Name)
+	}
+	if cfg.Scheme == "" {
+		cfg.Scheme = "http"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultDNSInterval
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = net.DefaultResolver
+	}
+	return &DNS{cfg: cfg, syncer: NewSyncer(pool, cfg.DrainTimeout)}, nil
+}
+
+// Run resolves immediately and then every interval until ctx is done. A
+// failed lookup leaves the pool unchanged.
+func (d *DNS) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Refresh(ctx); err != nil && ctx.Err() == nil {
+			publishError(d.cfg.Bus, "dns", d.syncer.Pool().Config().ServiceName, err)
+		}
+		select {
+		case <-ctx.Done():
+			d.syncer.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh resolves once and applies the result.
+func (d *DNS) Refresh(ctx context.Context) error {
+	targets, err := d.Resolve(ctx)
+	if err != nil {
+		return err
+	}
+	d.syncer.Apply(targets)
+	return nil
+}
+
+// Resolve returns the current targets for the configured name.
+func (d *DNS) Resolve(ctx context.Context) ([]Target, error) {
+	if d.cfg.SRV {
+		return d.resolveSRV(ctx)
+	}
+
+	addrs, err := d.cfg.Resolver.LookupIPAddr(ctx, d.cfg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", d.cfg.Name, err)
+	}
+	port := strconv.Itoa(d.cfg.Port)
+	targets := make([]Target, 0, len(addrs))
+	for _, a := range addrs {
+		targets = append(targets, d.target(a.IP.String(), port, 1))
+	}
+	return sortTargets(targets), nil
+}
+
+// resolveSRV keeps only the records of the lowest priority, as SRV clients
+// must only fall back to higher priorities when those are unreachable, which
+// the pool's health checks handle instead.
+func (d *DNS) resolveSRV(ctx context.Context) ([]Target, error) {
+	_, srvs, err := d.cfg.Resolver.LookupSRV(ctx, "", "", d.cfg.Name)
+	if err != nil {
+		return nil, fmt.Errorf("resolving SRV %s: %w", d.cfg.Name, err)
+	}
+	if len(srvs) == 0 {
+		return nil, nil
+	}
+
+	best := srvs[0].Priority
+	for _, s := range srvs {
+		if s.Priority < best {
+			best = s.Priority
+		}
+	}
+	targets := make([]Target, 0, len(srvs))
+	for _, s := range srvs {
+		if s.Priority != best {
+			continue
+		}
+		host := strings.TrimSuffix(s.Target, ".")
+		targets = append(targets, d.target(host, strconv.Itoa(int(s.Port)), uint32(s.Weight)))
+	}
+	return sortTargets(targets), nil
+}
+
+func (d *DNS) target(host, port string, weight uint32) Target {
+	u := &url.URL{Scheme: d.cfg.Scheme, Host: net.JoinHostPort(host, port)}
+	return Target{URL: u, Weight: weight}
+}
+
+func sortTargets(ts []Target) []Target {
+	sort.Slice(ts, func(i, j int) bool { return ts[i].id() < ts[j].id() })
+	return ts
+}
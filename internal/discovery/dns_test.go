@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+type fakeResolver struct {
+	ips  []net.IPAddr
+	srvs []*net.SRV
+	err  error
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.ips, r.err
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return name, r.srvs, r.err
+}
+
+func TestDNSResolveA(t *testing.T) {
+	res := &fakeResolver{ips: []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("fd00::1")}}}
+	pool := newTestPool()
+	d, err := NewDNS(DNSConfig{Name: "api.internal", Port: 8080, Resolver: res}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := poolState(pool); !equal(got, []string{"http://10.0.0.2:8080", "http://[fd00::1]:8080"}) {
+		t.Fatalf("unexpected backends %v", got)
+	}
+
+	t.Run("Lookup failure keeps backends", func(t *testing.T) {
+		res.err = errors.New("server misbehaving")
+		if err := d.Refresh(context.Background()); err == nil {
+			t.Fatal("expected error")
+		}
+		if got := poolState(pool); len(got) != 2 {
+			t.Errorf("expected backends kept, got %v", got)
+		}
+	})
+}
+
+func TestDNSResolveSRV(t *testing.T) {
+	res := &fakeResolver{srvs: []*net.SRV{
+		{Target: "a.internal.", Port: 9000, Priority: 10, Weight: 3},
+		{Target: "b.internal.", Port: 9001, Priority: 10, Weight: 0},
+		{Target: "backup.internal.", Port: 9000, Priority: 20, Weight: 5},
+	}}
+	d, err := NewDNS(DNSConfig{Name: "_http._tcp.api.internal", SRV: true, Resolver: res}, newTestPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := d.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected lowest-priority records only, got %+v", targets)
+	}
+	if targets[0].URL.String() != "http://a.internal:9000" || targets[0].weight() != 3 {
+		t.Errorf("unexpected target %+v", targets[0])
+	}
+	if targets[1].URL.String() != "http://b.internal:9001" || targets[1].weight() != 1 {
+		t.Errorf("unexpected target %+v", targets[1])
+	}
+}
+
+func TestDNSRunPublishesErrors(t *testing.T) {
+	bus := pubsub.New()
+	sub := bus.Subscribe(4, TopicError)
+	defer sub.Close()
+
+	res := &fakeResolver{err: errors.New("no such host")}
+	d, err := NewDNS(DNSConfig{Name: "api.internal", Port: 80, Resolver: res, Bus: bus, Interval: time.Hour}, newTestPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case ev := <-sub.C():
+		e, _ := pubsub.As[ErrorEvent](ev)
+		if e.Source != "dns" || e.Pool != "svc" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for error event")
+	}
+	cancel()
+	<-done
+}
+
+func TestNewDNSValidates(t *testing.T) {
+	if _, err := NewDNS(DNSConfig{}, newTestPool()); err == nil {
+		t.Error("expected error without name")
+	}
+	if _, err := NewDNS(DNSConfig{Name: "api.internal"}, newTestPool()); err == nil {
+		t.Error("expected error without port")
+	}
+}
@@ -0,0 +1,400 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+// Container labels read by the Docker provider. Hosts and paths take comma
+// separated lists; every host/path combination becomes a route.
+const (
+	LabelHost       = "balto.host"
+	LabelPath       = "balto.path"    // defaults to "/"
+	LabelPort       = "balto.port"    // container port; optional when exactly one port is exposed
+	LabelWeight     = "balto.weight"  // defaults to 1
+	LabelScheme     = "balto.scheme"  // defaults to "http"
+	LabelNetwork    = "balto.network" // network whose address is used when attached to several
+	LabelHealthType = "balto.health.type"
+	LabelHealthPath = "balto.health.path"
+)
+
+const (
+	DefaultDockerHost  = "unix:///var/run/docker.sock"
+	DefaultDockerRetry = 5 * time.Second
+)
+
+type DockerConfig struct {
+	Host          string        // "unix:///path" or "tcp://host:port", DefaultDockerHost when empty
+	RetryInterval time.Duration // wait before reconnecting, DefaultDockerRetry when zero
+	DrainTimeout  time.Duration // see NewSyncer
+
+	// Base, Swap, Shared and Bus are used as in FileConfig.
+	Base   *router.Router
+	Swap   func(*router.Router)
+	Shared *SharedRouter
+	Bus    *pubsub.Bus
+}
+
+// Docker builds routes from the labels of running containers and follows
+// container events to keep them current.
+type Docker struct {
+	cfg     DockerConfig
+	client  *http.Client
+	baseURL string
+
+	mu       sync.Mutex
+	routes   *routeTable
+	reported map[string]bool // errors already published
+}
+
+// dockerContainer is the subset of the Engine API container summary used.
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		PrivatePort uint16 `json:"PrivatePort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+func (c dockerContainer) name() string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+}
+
+func NewDocker(cfg DockerConfig) (*Docker, error) {
+	if cfg.Swap == nil && cfg.Shared == nil {
+		return nil, errors.New("docker discovery: swap function is required")
+	}
+	if cfg.Host == "" {
+		cfg.Host = DefaultDockerHost
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultDockerRetry
+	}
+
+	u, err := url.Parse(cfg.Host)
+	if err != nil {
+		return nil, fmt.Errorf("docker discovery: invalid host %q: %w", cfg.Host, err)
+	}
+	transport := &http.Transport{}
+	baseURL := ""
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://docker"
+	case "tcp", "http":
+		baseURL = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("docker discovery: unsupported host %q", cfg.Host)
+	}
+
+	return &Docker{
+		cfg: cfg,
+		// No client timeout: the event stream stays open. Requests carry
+		// their own deadlines.
+		client:   &http.Client{Transport: transport},
+		baseURL:  baseURL,
+		routes:   newRouteTable("docker", cfg.Shared, cfg.Base, cfg.Swap, cfg.Bus, cfg.DrainTimeout),
+		reported: make(map[string]bool),
+	}, nil
+}
+
+// Router returns the live router: the base routes, the containers and
+// the routes of any provider sharing it.
+func (d *Docker) Router() *router.Router {
+	return d.routes.shared.Router()
+}
+
+// Run subscribes to container events and refreshes on each relevant one
+// until ctx is done, reconnecting after errors.
+func (d *Docker) Run(ctx context.Context) {
+	for {
+		err := d.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			publishError(d.cfg.Bus, "docker", "", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.cfg.RetryInterval):
+		}
+	}
+}
+
+func (d *Docker) watch(ctx context.Context) error {
+	filters := `{"type":["container"]}`
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+"/events?filters="+url.QueryEscape(filters), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("subscribing to events: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("subscribing to events: unexpected status %d", resp.StatusCode)
+	}
+
+	// Listing after subscribing means no change can slip in between.
+	if err := d.Refresh(ctx); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev dockerEvent
+		if err := dec.Decode(&ev); err != nil {
+			return fmt.Errorf("reading events: %w", err)
+		}
+		if !relevantDockerEvent(ev) {
+			continue
+		}
+		if err := d.Refresh(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func relevantDockerEvent(ev dockerEvent) bool {
+	if ev.Type != "" && ev.Type != "container" {
+		return false
+	}
+	switch ev.Action {
+	case "start", "stop", "die", "kill", "destroy", "pause", "unpause", "restart":
+		return true
+	}
+	return false
+}
+
+// Refresh lists running containers and applies the routes their labels
+// describe. Containers with invalid labels are skipped and reported.
+func (d *Docker) Refresh(ctx context.Context) error {
+	containers, err := d.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	desired, errs := dockerRoutes(containers, d.routes.basePatterns())
+	reported := make(map[string]bool, len(errs))
+	for _, err := range errs {
+		msg := err.Error()
+		reported[msg] = true
+		if !d.reported[msg] {
+			publishError(d.cfg.Bus, "docker", "", err)
+		}
+	}
+	d.reported = reported
+
+	d.routes.apply(desired)
+	return nil
+}
+
+func (d *Docker) list(ctx context.Context) ([]dockerContainer, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filters := `{"label":["` + LabelHost + `"],"status":["running"]}`
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+"/containers/json?filters="+url.QueryEscape(filters), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listing containers: unexpected status %d", resp.StatusCode)
+	}
+
+	var out []dockerContainer
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	return out, nil
+}
+
+// dockerRoutes groups containers into routes. Health labels are taken from
+// the first container of a route in name order.
+func dockerRoutes(containers []dockerContainer, taken map[string]bool) (map[string]routeSpec, []error) {
+	sort.Slice(containers, func(i, j int) bool { return containers[i].name() < containers[j].name() })
+
+	var errs []error
+	desired := make(map[string]routeSpec)
+	for _, c := range containers {
+		hosts := splitList(c.Labels[LabelHost])
+		if len(hosts) == 0 {
+			continue
+		}
+		paths := splitList(c.Labels[LabelPath])
+		if len(paths) == 0 {
+			paths = []string{"/"}
+		}
+
+		if err := checkContainerLabels(c, hosts, paths); err != nil {
+			errs = append(errs, fmt.Errorf("container %s: %w", c.name(), err))
+			continue
+		}
+		u, weight, err := containerTarget(c)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("container %s: %w", c.name(), err))
+			continue
+		}
+
+		for _, host := range hosts {
+			for _, path := range paths {
+				key := router.RouteKey(router.Host(host), path)
+				if taken[router.PatternKey(router.Host(host), path, nil)] {
+					errs = append(errs, fmt.Errorf("container %s: route %s already defined by static configuration", c.name(), key))
+					continue
+				}
+				r, ok := desired[key]
+				if !ok {
+					r = routeSpec{host: router.Host(host), path: path, pool: router.DefaultPoolConfig()}
+					if t := c.Labels[LabelHealthType]; t != "" {
+						r.pool.ProbeType = t
+					}
+					if p := c.Labels[LabelHealthPath]; p != "" {
+						r.pool.ProbePath = p
+					}
+				}
+				r.targets = append(r.targets, Target{ID: router.BackendID(router.Host(host), u), URL: u, Weight: weight})
+				desired[key] = r
+			}
+		}
+	}
+	return desired, errs
+}
+
+// checkContainerLabels validates the route labels of c the way service files
+// are validated.
+func checkContainerLabels(c dockerContainer, hosts, paths []string) error {
+	for _, host := range hosts {
+		if err := router.ValidateHost(router.Host(host)); err != nil {
+			return fmt.Errorf("%s: %w", LabelHost, err)
+		}
+	}
+	for _, path := range paths {
+		if err := router.ValidatePath(path); err != nil {
+			return fmt.Errorf("%s: %w", LabelPath, err)
+		}
+	}
+	if err := checkProbeType(c.Labels[LabelHealthType]); err != nil {
+		return fmt.Errorf("%s: %w", LabelHealthType, err)
+	}
+	return nil
+}
+
+func containerTarget(c dockerContainer) (*url.URL, uint32, error) {
+	port := c.Labels[LabelPort]
+	if port == "" {
+		if len(c.Ports) != 1 {
+			return nil, 0, fmt.Errorf("%s label required with %d exposed ports", LabelPort, len(c.Ports))
+		}
+		port = strconv.Itoa(int(c.Ports[0].PrivatePort))
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return nil, 0, fmt.Errorf("invalid %s %q", LabelPort, port)
+	}
+
+	weight := uint32(1)
+	if w := c.Labels[LabelWeight]; w != "" {
+		n, err := strconv.ParseUint(w, 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid %s %q", LabelWeight, w)
+		}
+		weight = uint32(n)
+	}
+
+	scheme := c.Labels[LabelScheme]
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	ip, err := containerIP(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &url.URL{Scheme: scheme, Host: net.JoinHostPort(ip, port)}, weight, nil
+}
+
+func containerIP(c dockerContainer) (string, error) {
+	networks := c.NetworkSettings.Networks
+	if name := c.Labels[LabelNetwork]; name != "" {
+		n, ok := networks[name]
+		if !ok || n.IPAddress == "" && n.GlobalIPv6Address == "" {
+			return "", fmt.Errorf("no address on network %q", name)
+		}
+		if n.IPAddress != "" {
+			return n.IPAddress, nil
+		}
+		return n.GlobalIPv6Address, nil
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ip := networks[name].IPAddress; ip != "" {
+			return ip, nil
+		}
+		if ip := networks[name].GlobalIPv6Address; ip != "" {
+			return ip, nil
+		}
+	}
+	return "", errors.New("no network address")
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,231 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+// fakeDocker serves the Engine API endpoints the provider uses over a Unix
+// socket.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []map[string]any
+	events     chan string
+}
+
+func newFakeDocker(t *testing.T) (*fakeDocker, string) {
+	t.Helper()
+	fd := &fakeDocker{events: make(chan string, 4)}
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Query().Get("filters"), LabelHost) {
+			t.Errorf("expected label filter, got %q", r.URL.Query().Get("filters"))
+		}
+		fd.mu.Lock()
+		defer fd.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(fd.containers)
+	})
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-fd.events:
+				_, _ = w.Write([]byte(ev + "\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return fd, "unix://" + sock
+}
+
+func (fd *fakeDocker) set(containers ...map[string]any) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.containers = containers
+}
+
+func container(name, ip string, labels map[string]string) map[string]any {
+	return map[string]any{
+		"Id":     name + "-id",
+		"Names":  []string{"/" + name},
+		"Labels": labels,
+		"Ports":  []map[string]any{{"PrivatePort": 80, "Type": "tcp"}},
+		"NetworkSettings": map[string]any{
+			"Networks": map[string]any{"bridge": map[string]any{"IPAddress": ip}},
+		},
+	}
+}
+
+func TestDockerRefresh(t *testing.T) {
+	fd, host := newFakeDocker(t)
+	fd.set(
+		container("web-1", "172.17.0.2", map[string]string{LabelHost: "web.local", LabelWeight: "2", LabelHealthPath: "/ping"}),
+		container("web-2", "172.17.0.3", map[string]string{LabelHost: "web.local"}),
+		container("api", "172.17.0.4", map[string]string{LabelHost: "api.local,api2.local", LabelPath: "/v1", LabelPort: "8080"}),
+		container("broken", "172.17.0.5", map[string]string{LabelHost: "x.local", LabelPort: "http"}),
+	)
+
+	bus := pubsub.New()
+	sub := bus.Subscribe(8, TopicError)
+	defer sub.Close()
+
+	d, err := NewDocker(DockerConfig{Host: host, Bus: bus, Swap: func(*router.Router) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Router().Stop() }()
+
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rt := d.Router()
+	if got := routeKeys(rt); !equal(got, []string{"api.local/v1", "api2.local/v1", "web.local/"}) {
+		t.Fatalf("unexpected routes %v", got)
+	}
+	if got := routePool(t, rt, "api.local/v1"); !equal(got, []string{"api.local-http://172.17.0.4:8080"}) {
+		t.Errorf("unexpected api backends %v", got)
+	}
+	for _, ri := range rt.Routes() {
+		if ri.Key != "web.local/" {
+			continue
+		}
+		if ri.Route.Pool.Config().ProbePath != "/ping" {
+			t.Errorf("expected health path from labels, got %q", ri.Route.Pool.Config().ProbePath)
+		}
+		for _, b := range ri.Route.Pool.List() {
+			if strings.Contains(b.ID, "172.17.0.2") && b.Weight != 2 {
+				t.Errorf("expected weight 2, got %d", b.Weight)
+			}
+		}
+		if len(ri.Route.Pool.List()) != 2 {
+			t.Errorf("expected 2 web backends, got %d", len(ri.Route.Pool.List()))
+		}
+	}
+
+	select {
+	case ev := <-sub.C():
+		e, _ := pubsub.As[ErrorEvent](ev)
+		if !strings.Contains(e.Error, "container broken: invalid balto.port") {
+			t.Errorf("unexpected error event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected error event for broken container")
+	}
+}
+
+func TestDockerRejectsBadLabels(t *testing.T) {
+	fd, host := newFakeDocker(t)
+	fd.set(
+		container("web", "172.17.0.2", map[string]string{LabelHost: "web.local"}),
+		container("bad-host", "172.17.0.3", map[string]string{LabelHost: "a.*.local"}),
+		container("bad-path", "172.17.0.4", map[string]string{LabelHost: "files.local", LabelPath: "/files/*rest/x"}),
+		container("bad-health", "172.17.0.5", map[string]string{LabelHost: "ping.local", LabelHealthType: "icmp"}),
+	)
+
+	bus := pubsub.New()
+	sub := bus.Subscribe(8, TopicError)
+	defer sub.Close()
+
+	d, err := NewDocker(DockerConfig{Host: host, Bus: bus, Swap: func(*router.Router) {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Router().Stop() }()
+
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := routeKeys(d.Router()); !equal(got, []string{"web.local/"}) {
+		t.Fatalf("unexpected routes %v", got)
+	}
+
+	want := map[string]string{
+		"container bad-host: " + LabelHost:         "invalid host pattern",
+		"container bad-path: " + LabelPath:         "wildcard must be the last segment",
+		"container bad-health: " + LabelHealthType: `unknown health type "icmp"`,
+	}
+	for len(want) > 0 {
+		select {
+		case ev := <-sub.C():
+			e, _ := pubsub.As[ErrorEvent](ev)
+			for prefix, msg := range want {
+				if strings.Contains(e.Error, prefix) && strings.Contains(e.Error, msg) {
+					delete(want, prefix)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("missing error events %v", want)
+		}
+	}
+}
+
+func TestDockerFollowsEvents(t *testing.T) {
+	fd, host := newFakeDocker(t)
+	fd.set(container("web-1", "172.17.0.2", map[string]string{LabelHost: "web.local"}))
+
+	swaps := make(chan *router.Router, 4)
+	d, err := NewDocker(DockerConfig{Host: host, Bus: pubsub.New(), Swap: func(rt *router.Router) { swaps <- rt }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Router().Stop() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	waitSwap := func() *router.Router {
+		t.Helper()
+		select {
+		case rt := <-swaps:
+			return rt
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for router swap")
+			return nil
+		}
+	}
+
+	if got := routeKeys(waitSwap()); !equal(got, []string{"web.local/"}) {
+		t.Fatalf("unexpected routes %v", got)
+	}
+
+	fd.set(
+		container("web-1", "172.17.0.2", map[string]string{LabelHost: "web.local"}),
+		container("admin", "172.17.0.9", map[string]string{LabelHost: "admin.local"}),
+	)
+	fd.events <- `{"Type":"container","Action":"start"}`
+	if got := routeKeys(waitSwap()); !equal(got, []string{"admin.local/", "web.local/"}) {
+		t.Fatalf("unexpected routes after start %v", got)
+	}
+
+	fd.set(container("admin", "172.17.0.9", map[string]string{LabelHost: "admin.local"}))
+	fd.events <- `{"Type":"container","Action":"die"}`
+	if got := routeKeys(waitSwap()); !equal(got, []string{"admin.local/"}) {
+		t.Fatalf("unexpected routes after die %v", got)
+	}
+}
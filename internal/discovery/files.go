@@ -0,0 +1,406 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/diabeney/balto/internal/health"
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+const DefaultFileInterval = 2 * time.Second
+
+// ServiceFile is the format of a file in the services directory. Every
+// host/path combination becomes a route backed by the listed backends, or
+// answered by Redirect or Response, which take no backends.
+type ServiceFile struct {
+	Hosts      []string               `yaml:"hosts"`
+	Paths      []string               `yaml:"paths"` // defaults to "/"
+	Backends   []FileBackend          `yaml:"backends"`
+	Health     *ServiceHealth         `yaml:"health"`
+	Match      *router.Match          `yaml:"match"`       // request conditions, see router.Match
+	Rewrite    *router.Rewrite        `yaml:"rewrite"`     // backend path, see router.Rewrite
+	Headers    *router.Headers        `yaml:"headers"`     // header rules, see router.Headers
+	HostHeader router.HostHeader      `yaml:"host_header"` // "backend" (default), "preserve" or a fixed host
+	Redirect   *router.Redirect       `yaml:"redirect"`    // see router.Redirect
+	Response   *router.DirectResponse `yaml:"response"`    // see router.DirectResponse
+}
+
+type FileBackend struct {
+	URL    string `yaml:"url"`
+	Weight uint32 `yaml:"weight"`
+}
+
+// ServiceHealth overrides router.DefaultPoolConfig for the service's pools.
+type ServiceHealth struct {
+	Type     string        `yaml:"type"`
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type FileConfig struct {
+	Dir          string
+	Interval     time.Duration // polling interval, DefaultFileInterval when zero
+	DrainTimeout time.Duration // see NewSyncer
+
+	// Base holds the routes not managed by files; service files may not
+	// redefine them.
+	Base *router.Router
+	// Swap installs a new router, e.g. router.SetCurrent plus
+	// Proxy.UpdateRouter. Health checkers of the new router are already
+	// running; those it displaced are stopped after Swap returns.
+	Swap func(*router.Router)
+	// Shared replaces Base and Swap when other providers manage routes of
+	// the same router.
+	Shared *SharedRouter
+	Bus    *pubsub.Bus // receives ErrorEvents, pubsub.Default() when nil
+}
+
+// Files watches a directory of service files and applies additions, edits
+// and deletions to the live router. Backend changes are reconciled into the
+// existing pools; route changes produce a new router.
+type Files struct {
+	cfg FileConfig
+
+	mu        sync.Mutex
+	routes    *routeTable
+	files     map[string][]routeSpec       // last valid version of each file
+	sums      map[string][sha256.Size]byte // last seen content with its body files, valid or not
+	errs      map[string]error             // files whose last seen content is invalid
+	conflicts map[string]error             // files left out for redefining routes
+}
+
+func NewFiles(cfg FileConfig) (*Files, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("file discovery: directory is required")
+	}
+	if cfg.Swap == nil && cfg.Shared == nil {
+		return nil, errors.New("file discovery: swap function is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultFileInterval
+	}
+	return &Files{
+		cfg:       cfg,
+		routes:    newRouteTable("file", cfg.Shared, cfg.Base, cfg.Swap, cfg.Bus, cfg.DrainTimeout),
+		files:     make(map[string][]routeSpec),
+		sums:      make(map[string][sha256.Size]byte),
+		errs:      make(map[string]error),
+		conflicts: make(map[string]error),
+	}, nil
+}
+
+// Router returns the live router: the base routes, the service files and
+// the routes of any provider sharing it.
+func (f *Files) Router() *router.Router {
+	return f.routes.shared.Router()
+}
+
+// Errors returns the validation error of every service file that is
+// currently rejected. Rejected files keep their last valid version.
+func (f *Files) Errors() map[string]error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]error, len(f.errs)+len(f.conflicts))
+	for k, v := range f.conflicts {
+		out[k] = v
+	}
+	for k, v := range f.errs {
+		out[k] = v
+	}
+	return out
+}
+
+// Run refreshes every interval until ctx is done.
+func (f *Files) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = f.Refresh()
+		}
+	}
+}
+
+// Refresh rescans the directory and applies what changed. It returns the
+// validation errors of files rejected in this scan; they are also published.
+// A missing directory counts as empty.
+func (f *Files) Refresh() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.cfg.Dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		publishError(f.cfg.Bus, "file", "", err)
+		return err
+	}
+
+	var errs []error
+	changed := false
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isServiceFile(name) {
+			continue
+		}
+		seen[name] = true
+
+		data, err := os.ReadFile(filepath.Join(f.cfg.Dir, name))
+		if err != nil {
+			errs = append(errs, f.reject(name, err))
+			continue
+		}
+		sum := contentSum(data, f.files[name])
+		if prev, ok := f.sums[name]; ok && prev == sum {
+			continue
+		}
+		f.sums[name] = sum
+
+		routes, err := parseServiceFile(data, f.cfg.Dir)
+		if err != nil {
+			errs = append(errs, f.reject(name, err))
+			continue
+		}
+		delete(f.errs, name)
+		f.files[name] = routes
+		f.sums[name] = contentSum(data, routes)
+		changed = true
+	}
+	for name := range f.sums {
+		if !seen[name] {
+			delete(f.sums, name)
+			delete(f.errs, name)
+			delete(f.files, name)
+			changed = true
+		}
+	}
+
+	if changed {
+		desired, conflictErrs := f.desiredRoutes()
+		errs = append(errs, conflictErrs...)
+		f.routes.apply(desired)
+	}
+	return errors.Join(errs...)
+}
+
+// contentSum hashes a service file with the body files of its routes, so
+// editing a response file reloads the routes that serve it.
+func contentSum(data []byte, routes []routeSpec) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(data)
+	for _, r := range routes {
+		if r.response != nil && r.response.File != "" {
+			body, _ := os.ReadFile(r.response.File) // a missing file hashes as empty
+			h.Write(body)
+		}
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func (f *Files) reject(name string, err error) error {
+	err = fmt.Errorf("%s: %w", name, err)
+	f.errs[name] = err
+	publishError(f.cfg.Bus, "file", "", err)
+	return err
+}
+
+// desiredRoutes merges the loaded files in name order. A file whose routes
+// clash with the base router or an earlier file is left out as a whole; the
+// returned errors are the conflicts that are new since the last merge.
+func (f *Files) desiredRoutes() (map[string]routeSpec, []error) {
+	taken := make(map[string]string) // by pattern key
+	for pattern := range f.routes.basePatterns() {
+		taken[pattern] = "static configuration"
+	}
+
+	names := make([]string, 0, len(f.files))
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	conflicts := make(map[string]error)
+	desired := make(map[string]routeSpec)
+	for _, name := range names {
+		var conflict error
+		for _, r := range f.files[name] {
+			if owner, ok := taken[r.pattern()]; ok {
+				conflict = fmt.Errorf("%s: route %s already defined by %s", name, r.key(), owner)
+				break
+			}
+		}
+		if conflict != nil {
+			conflicts[name] = conflict
+			if prev, ok := f.conflicts[name]; !ok || prev.Error() != conflict.Error() {
+				publishError(f.cfg.Bus, "file", "", conflict)
+				errs = append(errs, conflict)
+			}
+			continue
+		}
+		for _, r := range f.files[name] {
+			taken[r.pattern()] = name
+			desired[r.key()] = r
+		}
+	}
+	f.conflicts = conflicts
+	return desired, errs
+}
+
+func isServiceFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return !strings.HasPrefix(name, ".")
+	}
+	return false
+}
+
+// parseServiceFile decodes and validates a service file into its routes. A
+// relative response file is resolved against dir, the services directory.
+func parseServiceFile(data []byte, dir string) ([]routeSpec, error) {
+	var sf ServiceFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sf); err != nil {
+		return nil, err
+	}
+
+	if len(sf.Hosts) == 0 {
+		return nil, errors.New("at least one host is required")
+	}
+	direct := sf.Redirect != nil || sf.Response != nil
+	switch {
+	case sf.Redirect != nil && sf.Response != nil:
+		return nil, errors.New("redirect and response are mutually exclusive")
+	case direct && (len(sf.Backends) > 0 || sf.Health != nil || sf.Rewrite != nil):
+		return nil, errors.New("redirect and response take no backends, health or rewrite")
+	case direct && sf.Headers != nil && sf.Headers.Request != nil:
+		return nil, errors.New("redirect and response take no request headers")
+	case direct && sf.HostHeader != "":
+		return nil, errors.New("redirect and response take no host header")
+	case !direct && len(sf.Backends) == 0:
+		return nil, errors.New("at least one backend is required")
+	}
+	if err := router.ValidateHostHeader(sf.HostHeader); err != nil {
+		return nil, err
+	}
+	if err := router.ValidateRedirect(sf.Redirect); err != nil {
+		return nil, err
+	}
+	if sf.Response != nil && sf.Response.File != "" && !filepath.IsAbs(sf.Response.File) {
+		sf.Response.File = filepath.Join(dir, sf.Response.File)
+	}
+	response, err := router.LoadResponse(sf.Response)
+	if err != nil {
+		return nil, err
+	}
+	sf.Response = response
+	paths := sf.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	for _, p := range paths {
+		if err := router.ValidatePath(p); err != nil {
+			return nil, err
+		}
+		if err := router.ValidateRewrite(p, sf.Rewrite); err != nil {
+			return nil, fmt.Errorf("path %s: %w", p, err)
+		}
+		if err := router.ValidateHeaders(p, sf.Headers); err != nil {
+			return nil, fmt.Errorf("path %s: %w", p, err)
+		}
+	}
+
+	targets := make([]Target, 0, len(sf.Backends))
+	for i, b := range sf.Backends {
+		u, err := url.Parse(b.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("backends[%d]: invalid url %q", i, b.URL)
+		}
+		targets = append(targets, Target{URL: u, Weight: b.Weight})
+	}
+
+	pool := router.DefaultPoolConfig()
+	if h := sf.Health; h != nil {
+		if err := checkProbeType(h.Type); err != nil {
+			return nil, err
+		}
+		if h.Type != "" {
+			pool.ProbeType = h.Type
+		}
+		if h.Path != "" {
+			pool.ProbePath = h.Path
+		}
+		if h.Interval > 0 {
+			pool.ProbeInterval = int(h.Interval.Milliseconds())
+		}
+		if h.Timeout > 0 {
+			pool.Timeout = int(h.Timeout.Milliseconds())
+		}
+	}
+	if err := health.ValidateHTTPProbe(&pool); err != nil {
+		return nil, err
+	}
+	if err := router.ValidateMatch(sf.Match); err != nil {
+		return nil, fmt.Errorf("match: %w", err)
+	}
+
+	var routes []routeSpec
+	seen := make(map[string]bool)
+	for _, h := range sf.Hosts {
+		host := router.Host(strings.TrimSpace(h))
+		if err := router.ValidateHost(host); err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			key := router.PatternKey(host, path, sf.Match)
+			if seen[key] {
+				return nil, fmt.Errorf("duplicate route %s", key)
+			}
+			seen[key] = true
+
+			ts := make([]Target, len(targets))
+			for i, t := range targets {
+				t.ID = router.BackendID(host, t.URL)
+				ts[i] = t
+			}
+			routes = append(routes, routeSpec{
+				host: host, path: path, pool: pool, targets: ts,
+				match: sf.Match, rewrite: sf.Rewrite, headers: sf.Headers, hostHeader: sf.HostHeader,
+				redirect: sf.Redirect, response: sf.Response,
+			})
+		}
+	}
+	return routes, nil
+}
+
+// checkProbeType returns an error unless t names a health probe. An empty t
+// keeps the default probe.
+func checkProbeType(t string) error {
+	switch strings.ToLower(t) {
+	case "", health.ProbeHTTP, health.ProbeTCP, health.ProbeTLS, health.ProbeGRPC:
+		return nil
+	}
+	return fmt.Errorf("unknown health type %q", t)
+}
@@ -0,0 +1,361 @@
+package discovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func routeKeys(rt *router.Router) []string {
+	var keys []string
+	for _, ri := range rt.Routes() {
+		keys = append(keys, ri.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func routePool(t *testing.T, rt *router.Router, key string) []string {
+	t.Helper()
+	for _, ri := range rt.Routes() {
+		if ri.Key == key {
+			return poolState(ri.Route.Pool)
+		}
+	}
+	t.Fatalf("route %s not found", key)
+	return nil
+}
+
+func TestFilesRefresh(t *testing.T) {
+	dir := t.TempDir()
+	static, _ := url.Parse("http://127.0.0.1:1")
+	base := router.NewRouter().Add("static.com", "/", []*url.URL{static})
+
+	var swaps []*router.Router
+	f, err := NewFiles(FileConfig{
+		Dir:          dir,
+		Base:         base,
+		DrainTimeout: 50 * time.Millisecond,
+		Bus:          pubsub.New(),
+		Swap:         func(rt *router.Router) { swaps = append(swaps, rt) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Router().Stop() }()
+
+	writeFile(t, dir, "api.yaml", `
+hosts: [api.com]
+paths: [/v1]
+backends:
+  - url: http://10.0.0.1:80
+  - url: http://10.0.0.2:80
+    weight: 3
+health:
+  path: /healthz
+  interval: 5s
+`)
+	writeFile(t, dir, "notes.txt", "ignored")
+
+	if err := f.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	rt := f.Router()
+	if got := routeKeys(rt); !equal(got, []string{"api.com/v1", "static.com/"}) {
+		t.Fatalf("unexpected routes %v", got)
+	}
+	if len(swaps) != 1 || swaps[0] != rt {
+		t.Fatalf("expected one swap to the new router, got %d", len(swaps))
+	}
+	for _, ri := range rt.Routes() {
+		if ri.Key == "api.com/v1" {
+			if cfg := ri.Route.Pool.Config(); cfg.ProbePath != "/healthz" || cfg.ProbeInterval != 5000 {
+				t.Errorf("health settings not applied: %+v", cfg)
+			}
+			for _, b := range ri.Route.Pool.List() {
+				if strings.HasSuffix(b.ID, "10.0.0.2:80") && b.Weight != 3 {
+					t.Errorf("expected weight 3, got %d", b.Weight)
+				}
+			}
+		}
+	}
+
+	t.Run("Unchanged files are not reapplied", func(t *testing.T) {
+		if err := f.Refresh(); err != nil || len(swaps) != 1 {
+			t.Errorf("expected no swap, got %d swaps (err %v)", len(swaps), err)
+		}
+	})
+
+	t.Run("Backend edits reuse the pool", func(t *testing.T) {
+		writeFile(t, dir, "api.yaml", `
+hosts: [api.com]
+paths: [/v1]
+backends:
+  - url: http://10.0.0.1:80
+health:
+  path: /healthz
+  interval: 5s
+`)
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if len(swaps) != 1 {
+			t.Fatalf("expected backends reconciled in place, got %d swaps", len(swaps))
+		}
+		time.Sleep(100 * time.Millisecond)
+		if got := routePool(t, f.Router(), "api.com/v1"); !equal(got, []string{"api.com-http://10.0.0.1:80"}) {
+			t.Errorf("unexpected backends %v", got)
+		}
+	})
+
+	t.Run("Invalid files keep their last valid version", func(t *testing.T) {
+		writeFile(t, dir, "api.yaml", "hosts: [api.com]\nbackends:\n  - url: ftp://nope\n")
+		err := f.Refresh()
+		if err == nil || !strings.Contains(err.Error(), "api.yaml: backends[0]: invalid url") {
+			t.Fatalf("expected validation error, got %v", err)
+		}
+		if _, ok := f.Errors()["api.yaml"]; !ok {
+			t.Error("expected error recorded for api.yaml")
+		}
+		if got := routeKeys(f.Router()); !equal(got, []string{"api.com/v1", "static.com/"}) {
+			t.Errorf("expected previous routes kept, got %v", got)
+		}
+	})
+
+	t.Run("Conflicting routes are rejected", func(t *testing.T) {
+		writeFile(t, dir, "zz.yaml", "hosts: [static.com]\nbackends:\n  - url: http://10.0.0.9:80\n")
+		err := f.Refresh()
+		if err == nil || !strings.Contains(err.Error(), "already defined by static configuration") {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+		if got := routeKeys(f.Router()); !equal(got, []string{"api.com/v1", "static.com/"}) {
+			t.Errorf("unexpected routes %v", got)
+		}
+	})
+
+	t.Run("Conditional routes coexist with plain ones", func(t *testing.T) {
+		_ = os.Remove(filepath.Join(dir, "zz.yaml"))
+		writeFile(t, dir, "v2.yaml", "hosts: [api.com]\npaths: [/v1]\nmatch:\n  headers:\n    - name: x-api-version\n      value: \"2\"\nbackends:\n  - url: http://10.0.0.5:80\n")
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"api.com/v1", "api.com/v1 [header:X-Api-Version=2]", "static.com/"}
+		if got := routeKeys(f.Router()); !equal(got, want) {
+			t.Errorf("unexpected routes %v", got)
+		}
+		if got := routePool(t, f.Router(), want[1]); !equal(got, []string{"api.com-http://10.0.0.5:80"}) {
+			t.Errorf("unexpected backends %v", got)
+		}
+	})
+
+	t.Run("Redirect routes need no backends", func(t *testing.T) {
+		writeFile(t, dir, "legacy.yaml", "hosts: [api.com]\npaths: [/old/*]\nredirect:\n  url: /v1\n  preserve_path: true\n")
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		route, _, ok := f.Router().Lookup("api.com", "/old/x")
+		if !ok || route.Redirect == nil || route.Pool != nil {
+			t.Fatalf("expected a redirect route, got %+v", route)
+		}
+		writeFile(t, dir, "legacy.yaml", "hosts: [api.com]\npaths: [/old/*]\nredirect:\n  url: /v2\n")
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if route, _, _ := f.Router().Lookup("api.com", "/old/x"); route.Redirect == nil || route.Redirect.URL != "/v2" {
+			t.Errorf("redirect not updated: %+v", route.Redirect)
+		}
+		_ = os.Remove(filepath.Join(dir, "legacy.yaml"))
+	})
+
+	t.Run("Response files are read from the directory and reloaded", func(t *testing.T) {
+		writeFile(t, dir, "robots.txt", "v1")
+		writeFile(t, dir, "robots.yaml", "hosts: [api.com]\npaths: [/robots.txt]\nresponse:\n  file: robots.txt\n")
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		body := func() string {
+			route, _, ok := f.Router().Lookup("api.com", "/robots.txt")
+			if !ok || route.Response == nil {
+				t.Fatalf("expected a response route, got %+v", route)
+			}
+			w := httptest.NewRecorder()
+			route.ServeDirect(w, router.HeaderContext{Request: httptest.NewRequest(http.MethodGet, "/robots.txt", nil)})
+			return w.Body.String()
+		}
+		if got := body(); got != "v1" {
+			t.Fatalf("got body %q", got)
+		}
+
+		writeFile(t, dir, "robots.txt", "v2")
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if got := body(); got != "v2" {
+			t.Errorf("edited response file not reloaded, got %q", got)
+		}
+		_ = os.Remove(filepath.Join(dir, "robots.yaml"))
+	})
+
+	t.Run("Deleted files drop their routes", func(t *testing.T) {
+		pool := func() *backendpool.Pool {
+			for _, ri := range f.Router().Routes() {
+				if ri.Key == "api.com/v1" {
+					return ri.Route.Pool
+				}
+			}
+			return nil
+		}
+		before := pool()
+
+		_ = os.Remove(filepath.Join(dir, "v2.yaml"))
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if got := routeKeys(f.Router()); !equal(got, []string{"api.com/v1", "static.com/"}) {
+			t.Errorf("unexpected routes %v", got)
+		}
+		if pool() != before {
+			t.Error("removing a route rebuilt an unrelated one")
+		}
+
+		_ = os.Remove(filepath.Join(dir, "api.yaml"))
+		if err := f.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if got := routeKeys(f.Router()); !equal(got, []string{"static.com/"}) {
+			t.Errorf("unexpected routes %v", got)
+		}
+		if len(f.Errors()) != 0 {
+			t.Errorf("expected errors cleared, got %v", f.Errors())
+		}
+	})
+}
+
+func TestSharedRouter(t *testing.T) {
+	static, _ := url.Parse("http://127.0.0.1:1")
+	var swaps int
+	shared := NewSharedRouter(router.NewRouter().Add("static.com", "/", []*url.URL{static}), func(*router.Router) { swaps++ })
+	defer func() { _ = shared.Router().Stop() }()
+
+	bus := pubsub.New()
+	errs := bus.Subscribe(8, TopicError)
+	defer errs.Close()
+
+	dirA, dirB := t.TempDir(), t.TempDir()
+	a, err := NewFiles(FileConfig{Dir: dirA, Shared: shared, Bus: bus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFiles(FileConfig{Dir: dirB, Shared: shared, Bus: bus})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, dirA, "a.yaml", "hosts: [a.com]\nbackends:\n  - url: http://10.0.0.1:80\n")
+	writeFile(t, dirB, "b.yaml", "hosts: [b.com]\nbackends:\n  - url: http://10.0.0.2:80\n")
+	if err := a.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.com/", "b.com/", "static.com/"}
+	if got := routeKeys(shared.Router()); !equal(got, want) || swaps != 2 {
+		t.Fatalf("expected both providers' routes after 2 swaps, got %v after %d", got, swaps)
+	}
+
+	t.Run("A provider's changes keep the other's routes", func(t *testing.T) {
+		writeFile(t, dirA, "a2.yaml", "hosts: [a2.com]\nbackends:\n  - url: http://10.0.0.3:80\n")
+		if err := a.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a.com/", "a2.com/", "b.com/", "static.com/"}
+		if got := routeKeys(b.Router()); !equal(got, want) {
+			t.Errorf("unexpected routes %v", got)
+		}
+	})
+
+	t.Run("Routes of another provider are not overwritten", func(t *testing.T) {
+		writeFile(t, dirB, "dup.yaml", "hosts: [a.com]\nbackends:\n  - url: http://10.0.0.9:80\n")
+		if err := b.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if got := routePool(t, shared.Router(), "a.com/"); !equal(got, []string{"a.com-http://10.0.0.1:80"}) {
+			t.Errorf("expected a.com kept, got backends %v", got)
+		}
+		select {
+		case ev := <-errs.C():
+			if e := ev.Data.(ErrorEvent); e.Source != "file" || !strings.Contains(e.Error, "a.com/") {
+				t.Errorf("unexpected error event %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the clash to be reported")
+		}
+
+		// Once the owner drops it, the other provider takes it over.
+		_ = os.Remove(filepath.Join(dirA, "a.yaml"))
+		if err := a.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, dirB, "b.yaml", "hosts: [b.com]\nbackends:\n  - url: http://10.0.0.4:80\n")
+		if err := b.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if got := routePool(t, shared.Router(), "a.com/"); !equal(got, []string{"a.com-http://10.0.0.9:80"}) {
+			t.Errorf("expected a.com from the second provider, got backends %v", got)
+		}
+	})
+}
+
+func TestParseServiceFile(t *testing.T) {
+	cases := map[string]string{
+		"unknown field":          "hosts: [a.com]\nbackend: []\n",
+		"no hosts":               "backends:\n  - url: http://x:80\n",
+		"no backends":            "hosts: [a.com]\n",
+		"duplicate":              "hosts: [a.com, A.com]\nbackends:\n  - url: http://x:80\n",
+		"duplicate after trim":   "hosts: [a.com, \" a.com \"]\nbackends:\n  - url: http://x:80\n",
+		"bad probe type":         "hosts: [a.com]\nbackends:\n  - url: http://x:80\nhealth:\n  type: bogus\n",
+		"ambiguous":              "hosts: [a.com]\npaths: [/u/:id, /u/:name]\nbackends:\n  - url: http://x:80\n",
+		"bad path":               "hosts: [a.com]\npaths: [\"/a/:id{[}\"]\nbackends:\n  - url: http://x:80\n",
+		"bad rewrite":            "hosts: [a.com]\npaths: [/u/:id]\nbackends:\n  - url: http://x:80\nrewrite:\n  template: /{name}\n",
+		"bad match":              "hosts: [a.com]\nbackends:\n  - url: http://x:80\nmatch:\n  query:\n    - regex: x\n",
+		"redirect with backends": "hosts: [a.com]\nbackends:\n  - url: http://x:80\nredirect:\n  url: /new\n",
+		"bad redirect":           "hosts: [a.com]\nredirect:\n  url: /new\n  status: 200\n",
+		"missing response file":  "hosts: [a.com]\nresponse:\n  file: /nonexistent/robots.txt\n",
+		"bad header variable":    "hosts: [a.com]\npaths: [/u/:id]\nbackends:\n  - url: http://x:80\nheaders:\n  request:\n    set:\n      X-User: \"{param.name}\"\n",
+		"bad host header":        "hosts: [a.com]\nbackends:\n  - url: http://x:80\nhost_header: \"a.com/x\"\n",
+		"response request rules": "hosts: [a.com]\nresponse:\n  body: ok\nheaders:\n  request:\n    remove: [Cookie]\n",
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseServiceFile([]byte(data), t.TempDir()); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	routes, err := parseServiceFile([]byte("hosts: [\" a.com \"]\nbackends:\n  - url: http://x:80\n"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := routes[0]; r.host != "a.com" || r.targets[0].ID != "a.com-http://x:80" {
+		t.Errorf("expected the trimmed host, got %q with backend %q", r.host, r.targets[0].ID)
+	}
+}
@@ -0,0 +1,607 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+// Annotations read from Ingress resources.
+const (
+	AnnotationHealthType = "balto.io/health-type"
+	AnnotationHealthPath = "balto.io/health-path"
+)
+
+const (
+	DefaultKubernetesRetry = 5 * time.Second
+
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+	serviceNameLabel       = "kubernetes.io/service-name"
+	serviceAccountDir      = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+type KubernetesConfig struct {
+	APIServer string      // e.g. "https://10.96.0.1:443"
+	Token     string      // bearer token
+	TokenFile string      // re-read on every request, so rotated tokens are picked up; wins over Token
+	TLSConfig *tls.Config // nil uses the system roots
+
+	Namespace    string // watch a single namespace; all when empty
+	IngressClass string // only Ingresses of this class; all when empty
+
+	RetryInterval time.Duration // wait before re-listing after errors, DefaultKubernetesRetry when zero
+	DrainTimeout  time.Duration // see NewSyncer
+
+	// Base, Swap, Shared and Bus are used as in FileConfig.
+	Base   *router.Router
+	Swap   func(*router.Router)
+	Shared *SharedRouter
+	Bus    *pubsub.Bus
+}
+
+// InClusterConfig returns the API server address and service account
+// credentials of the pod Balto runs in.
+func InClusterConfig() (KubernetesConfig, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return KubernetesConfig{}, errors.New("kubernetes discovery: not running in a cluster")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return KubernetesConfig{}, fmt.Errorf("kubernetes discovery: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return KubernetesConfig{}, errors.New("kubernetes discovery: no certificates in service account CA")
+	}
+	return KubernetesConfig{
+		APIServer: "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		TLSConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+	}, nil
+}
+
+// Kubernetes runs Balto as an ingress controller: Ingresses define the
+// routes and EndpointSlices their backends. Ready endpoints serve traffic,
+// terminating ones that are still serving drain.
+type Kubernetes struct {
+	cfg    KubernetesConfig
+	client *http.Client
+	resync chan struct{}
+
+	mu       sync.Mutex
+	caches   []*k8sCache
+	routes   *routeTable
+	reported map[string]bool
+}
+
+// k8sCache mirrors one resource type through list and watch.
+type k8sCache struct {
+	path   string
+	items  map[string]json.RawMessage // by namespace/name
+	listed bool
+}
+
+type k8sMeta struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	ResourceVersion   string            `json:"resourceVersion"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
+}
+
+type k8sIngress struct {
+	Metadata k8sMeta `json:"metadata"`
+	Spec     struct {
+		IngressClassName *string `json:"ingressClassName"`
+		Rules            []struct {
+			Host string `json:"host"`
+			HTTP *struct {
+				Paths []struct {
+					Path     string            `json:"path"`
+					PathType string            `json:"pathType"`
+					Backend  k8sIngressBackend `json:"backend"`
+				} `json:"paths"`
+			} `json:"http"`
+		} `json:"rules"`
+	} `json:"spec"`
+}
+
+type k8sIngressBackend struct {
+	Service *struct {
+		Name string `json:"name"`
+		Port struct {
+			Name   string `json:"name"`
+			Number int32  `json:"number"`
+		} `json:"port"`
+	} `json:"service"`
+}
+
+type k8sService struct {
+	Metadata k8sMeta `json:"metadata"`
+	Spec     struct {
+		Ports []struct {
+			Name string `json:"name"`
+			Port int32  `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+type k8sEndpointSlice struct {
+	Metadata    k8sMeta `json:"metadata"`
+	AddressType string  `json:"addressType"`
+	Endpoints   []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready       *bool `json:"ready"`
+			Serving     *bool `json:"serving"`
+			Terminating *bool `json:"terminating"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int32  `json:"port"`
+	} `json:"ports"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+func NewKubernetes(cfg KubernetesConfig) (*Kubernetes, error) {
+	if cfg.APIServer == "" {
+		return nil, errors.New("kubernetes discovery: API server is required")
+	}
+	if cfg.Swap == nil && cfg.Shared == nil {
+		return nil, errors.New("kubernetes discovery: swap function is required")
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultKubernetesRetry
+	}
+	cfg.APIServer = strings.TrimSuffix(cfg.APIServer, "/")
+
+	ns := ""
+	if cfg.Namespace != "" {
+		ns = "/namespaces/" + url.PathEscape(cfg.Namespace)
+	}
+	return &Kubernetes{
+		cfg: cfg,
+		// No client timeout: watches stay open. Lists carry their own deadline.
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: cfg.TLSConfig}},
+		resync: make(chan struct{}, 1),
+		caches: []*k8sCache{
+			{path: "/apis/networking.k8s.io/v1" + ns + "/ingresses"},
+			{path: "/api/v1" + ns + "/services"},
+			{path: "/apis/discovery.k8s.io/v1" + ns + "/endpointslices"},
+		},
+		routes:   newRouteTable("kubernetes", cfg.Shared, cfg.Base, cfg.Swap, cfg.Bus, cfg.DrainTimeout),
+		reported: make(map[string]bool),
+	}, nil
+}
+
+// Router returns the live router: the base routes, the Ingresses and
+// the routes of any provider sharing it.
+func (k *Kubernetes) Router() *router.Router {
+	return k.routes.shared.Router()
+}
+
+// Run watches Ingresses, Services and EndpointSlices until ctx is done.
+// Routes are applied once every resource type has been listed.
+func (k *Kubernetes) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range k.caches {
+		wg.Add(1)
+		go func(c *k8sCache) {
+			defer wg.Done()
+			k.informer(ctx, c)
+		}(c)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-k.resync:
+			k.sync()
+		}
+	}
+}
+
+func (k *Kubernetes) notify() {
+	select {
+	case k.resync <- struct{}{}:
+	default:
+	}
+}
+
+func (k *Kubernetes) informer(ctx context.Context, c *k8sCache) {
+	rv := ""
+	for ctx.Err() == nil {
+		var err error
+		if rv == "" {
+			rv, err = k.list(ctx, c)
+		}
+		if err == nil {
+			rv, err = k.watch(ctx, c, rv)
+		}
+		if ctx.Err() != nil || (err == nil && rv != "") {
+			continue
+		}
+
+		// The watch failed or cannot resume, e.g. with 410 Gone. Either way
+		// wait before listing again so a failing API server is not hammered.
+		if err != nil {
+			publishError(k.cfg.Bus, "kubernetes", "", err)
+		}
+		rv = ""
+		select {
+		case <-ctx.Done():
+		case <-time.After(k.cfg.RetryInterval):
+		}
+	}
+}
+
+func (k *Kubernetes) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := k.cfg.APIServer + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	token := k.cfg.Token
+	if k.cfg.TokenFile != "" {
+		b, err := os.ReadFile(k.cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %d", path, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// list replaces the cache contents and returns the list's resource version.
+func (k *Kubernetes) list(ctx context.Context, c *k8sCache) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	resp, err := k.get(ctx, c.path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Metadata k8sMeta           `json:"metadata"`
+		Items    []json.RawMessage `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("listing %s: %w", c.path, err)
+	}
+
+	items := make(map[string]json.RawMessage, len(list.Items))
+	for _, raw := range list.Items {
+		var obj struct {
+			Metadata k8sMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return "", fmt.Errorf("listing %s: %w", c.path, err)
+		}
+		items[obj.Metadata.Namespace+"/"+obj.Metadata.Name] = raw
+	}
+
+	k.mu.Lock()
+	c.items = items
+	c.listed = true
+	k.mu.Unlock()
+	k.notify()
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watch applies events to the cache until the stream ends and returns the
+// version to resume from, or "" when the cache must be listed again.
+func (k *Kubernetes) watch(ctx context.Context, c *k8sCache, rv string) (string, error) {
+	resp, err := k.get(ctx, c.path, url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {rv},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev k8sWatchEvent
+		if err := dec.Decode(&ev); err != nil {
+			// Watches end when the API server's timeout expires; resume
+			// from the last version seen.
+			return rv, nil
+		}
+		if ev.Type == "ERROR" {
+			// Usually 410 Gone: the version is too old to resume from.
+			return "", nil
+		}
+
+		var obj struct {
+			Metadata k8sMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(ev.Object, &obj); err != nil {
+			return "", fmt.Errorf("watching %s: %w", c.path, err)
+		}
+		rv = obj.Metadata.ResourceVersion
+		if ev.Type == "BOOKMARK" {
+			continue
+		}
+
+		key := obj.Metadata.Namespace + "/" + obj.Metadata.Name
+		k.mu.Lock()
+		if ev.Type == "DELETED" {
+			delete(c.items, key)
+		} else {
+			c.items[key] = ev.Object
+		}
+		k.mu.Unlock()
+		k.notify()
+	}
+}
+
+func (k *Kubernetes) sync() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, c := range k.caches {
+		if !c.listed {
+			return
+		}
+	}
+
+	var ingresses []k8sIngress
+	decodeItems(k.caches[0].items, &ingresses)
+	var services []k8sService
+	decodeItems(k.caches[1].items, &services)
+	var slices []k8sEndpointSlice
+	decodeItems(k.caches[2].items, &slices)
+
+	desired, errs := kubernetesRoutes(ingresses, services, slices, k.cfg.IngressClass, k.routes.basePatterns())
+	reported := make(map[string]bool, len(errs))
+	for _, err := range errs {
+		msg := err.Error()
+		reported[msg] = true
+		if !k.reported[msg] {
+			publishError(k.cfg.Bus, "kubernetes", "", err)
+		}
+	}
+	k.reported = reported
+
+	k.routes.apply(desired)
+}
+
+func decodeItems[T any](items map[string]json.RawMessage, out *[]T) {
+	for _, raw := range items {
+		var v T
+		if err := json.Unmarshal(raw, &v); err == nil {
+			*out = append(*out, v)
+		}
+	}
+}
+
+// kubernetesRoutes maps Ingress rules to routes. Exact paths become exact
+// routes and Prefix (or ImplementationSpecific) paths wildcard routes; both
+// forward the original path. When several Ingresses claim the same host and
+// path the oldest wins.
+func kubernetesRoutes(ingresses []k8sIngress, services []k8sService, slices []k8sEndpointSlice, class string, taken map[string]bool) (map[string]routeSpec, []error) {
+	sort.Slice(ingresses, func(i, j int) bool {
+		a, b := ingresses[i].Metadata, ingresses[j].Metadata
+		if !a.CreationTimestamp.Equal(b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(b.CreationTimestamp)
+		}
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	svcByName := make(map[string]*k8sService, len(services))
+	for i := range services {
+		m := services[i].Metadata
+		svcByName[m.Namespace+"/"+m.Name] = &services[i]
+	}
+	slicesBySvc := make(map[string][]k8sEndpointSlice)
+	for _, s := range slices {
+		key := s.Metadata.Namespace + "/" + s.Metadata.Labels[serviceNameLabel]
+		slicesBySvc[key] = append(slicesBySvc[key], s)
+	}
+
+	var errs []error
+	owners := make(map[string]string)
+	desired := make(map[string]routeSpec)
+	for _, ing := range ingresses {
+		if !ingressMatchesClass(ing, class) {
+			continue
+		}
+		name := ing.Metadata.Namespace + "/" + ing.Metadata.Name
+
+		pool := router.DefaultPoolConfig()
+		if t := ing.Metadata.Annotations[AnnotationHealthType]; t != "" {
+			if err := checkProbeType(t); err != nil {
+				errs = append(errs, fmt.Errorf("ingress %s: %s: %w", name, AnnotationHealthType, err))
+				continue
+			}
+			pool.ProbeType = t
+		}
+		if p := ing.Metadata.Annotations[AnnotationHealthPath]; p != "" {
+			pool.ProbePath = p
+		}
+
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			// Rules without a host catch every request, like the default host.
+			host := router.Host(rule.Host)
+			if host == "" {
+				host = router.DefaultHost
+			}
+			if err := router.ValidateHost(host); err != nil {
+				errs = append(errs, fmt.Errorf("ingress %s: %w", name, err))
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				routePath, err := ingressPath(p.Path, p.PathType)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("ingress %s: %w", name, err))
+					continue
+				}
+				key := router.RouteKey(host, routePath)
+				if taken[router.PatternKey(host, routePath, nil)] {
+					errs = append(errs, fmt.Errorf("ingress %s: route %s already defined by static configuration", name, key))
+					continue
+				}
+				if owner, ok := owners[key]; ok {
+					errs = append(errs, fmt.Errorf("ingress %s: route %s already defined by ingress %s", name, key, owner))
+					continue
+				}
+				svc := p.Backend.Service
+				if svc == nil {
+					errs = append(errs, fmt.Errorf("ingress %s: only service backends are supported", name))
+					continue
+				}
+
+				svcKey := ing.Metadata.Namespace + "/" + svc.Name
+				targets, err := endpointTargets(host, svcByName[svcKey], slicesBySvc[svcKey], svc.Port.Name, svc.Port.Number)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("ingress %s: service %s: %w", name, svc.Name, err))
+				}
+				owners[key] = name
+				desired[key] = routeSpec{
+					host:    host,
+					path:    routePath,
+					pool:    pool,
+					targets: targets,
+					rewrite: &router.Rewrite{Keep: true},
+				}
+			}
+		}
+	}
+	return desired, errs
+}
+
+func ingressMatchesClass(ing k8sIngress, class string) bool {
+	if class == "" {
+		return true
+	}
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName == class
+	}
+	return ing.Metadata.Annotations[ingressClassAnnotation] == class
+}
+
+func ingressPath(path, pathType string) (string, error) {
+	if path == "" {
+		path = "/"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("path %q must be absolute", path)
+	}
+	switch pathType {
+	case "Exact":
+		return path, nil
+	case "Prefix", "ImplementationSpecific", "":
+		return strings.TrimSuffix(path, "/") + "/*", nil
+	default:
+		return "", fmt.Errorf("unknown path type %q", pathType)
+	}
+}
+
+// endpointTargets returns the serving endpoints of a service on the port an
+// Ingress backend refers to, by name or by service port number.
+func endpointTargets(host router.Host, svc *k8sService, slices []k8sEndpointSlice, portName string, portNumber int32) ([]Target, error) {
+	if portName == "" && svc != nil {
+		found := false
+		for _, p := range svc.Spec.Ports {
+			if p.Port == portNumber {
+				portName, found = p.Name, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no port %d", portNumber)
+		}
+	}
+	byName := portName != "" || svc != nil
+
+	seen := make(map[string]bool)
+	var targets []Target
+	for _, s := range slices {
+		port, ok := slicePort(s, portName, byName)
+		if !ok {
+			continue
+		}
+		for _, ep := range s.Endpoints {
+			c := ep.Conditions
+			ready := c.Ready == nil || *c.Ready
+			terminating := c.Terminating != nil && *c.Terminating
+			serving := c.Serving != nil && *c.Serving
+			if !ready && !(terminating && serving) {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				u := &url.URL{Scheme: "http", Host: net.JoinHostPort(addr, fmt.Sprint(port))}
+				id := router.BackendID(host, u)
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				targets = append(targets, Target{ID: id, URL: u, Draining: !ready})
+			}
+		}
+	}
+	return sortTargets(targets), nil
+}
+
+// slicePort finds the slice port matching name. Without a name (the Service
+// is unknown and the Ingress gives a number) only single-port slices match.
+func slicePort(s k8sEndpointSlice, name string, byName bool) (int32, bool) {
+	for _, p := range s.Ports {
+		if p.Port == nil {
+			continue
+		}
+		pname := ""
+		if p.Name != nil {
+			pname = *p.Name
+		}
+		if byName && pname == name || !byName && len(s.Ports) == 1 {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
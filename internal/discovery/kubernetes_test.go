@@ -0,0 +1,322 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+const (
+	ingressesPath     = "/apis/networking.k8s.io/v1/ingresses"
+	servicePath       = "/api/v1/services"
+	endpointSlicePath = "/apis/discovery.k8s.io/v1/endpointslices"
+)
+
+// fakeAPIServer serves list and watch requests for the resources the
+// controller uses.
+type fakeAPIServer struct {
+	mu     sync.Mutex
+	items  map[string][]any
+	lists  map[string]int
+	events map[string]chan string
+}
+
+func newFakeAPIServer(t *testing.T) (*fakeAPIServer, string) {
+	t.Helper()
+	f := &fakeAPIServer{items: make(map[string][]any), lists: make(map[string]int), events: make(map[string]chan string)}
+	for _, p := range []string{ingressesPath, servicePath, endpointSlicePath} {
+		f.events[p] = make(chan string, 8)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		events, ok := f.events[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("watch") == "" {
+			f.mu.Lock()
+			items := f.items[r.URL.Path]
+			f.lists[r.URL.Path]++
+			f.mu.Unlock()
+			if items == nil {
+				items = []any{}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"metadata": map[string]any{"resourceVersion": "1"}, "items": items})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-events:
+				_, _ = w.Write([]byte(ev + "\n"))
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv.URL
+}
+
+func (f *fakeAPIServer) set(path string, items ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[path] = items
+}
+
+func (f *fakeAPIServer) listed(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lists[path]
+}
+
+func (f *fakeAPIServer) send(t *testing.T, path, typ string, obj any) {
+	t.Helper()
+	b, _ := json.Marshal(map[string]any{"type": typ, "object": obj})
+	f.events[path] <- string(b)
+}
+
+func meta(ns, name, rv string) map[string]any {
+	return map[string]any{"namespace": ns, "name": name, "resourceVersion": rv, "creationTimestamp": "2024-01-01T00:00:00Z"}
+}
+
+func ingress(name, class string, rules ...any) map[string]any {
+	return map[string]any{
+		"metadata": meta("default", name, "1"),
+		"spec":     map[string]any{"ingressClassName": class, "rules": rules},
+	}
+}
+
+func rule(host, path, pathType, svc string, port any) map[string]any {
+	portRef := map[string]any{"number": port}
+	if name, ok := port.(string); ok {
+		portRef = map[string]any{"name": name}
+	}
+	return map[string]any{
+		"host": host,
+		"http": map[string]any{"paths": []any{map[string]any{
+			"path":     path,
+			"pathType": pathType,
+			"backend":  map[string]any{"service": map[string]any{"name": svc, "port": portRef}},
+		}}},
+	}
+}
+
+func endpointSlice(name, svc, rv string, endpoints ...any) map[string]any {
+	m := meta("default", name, rv)
+	m["labels"] = map[string]any{serviceNameLabel: svc}
+	return map[string]any{
+		"metadata":    m,
+		"addressType": "IPv4",
+		"endpoints":   endpoints,
+		"ports":       []any{map[string]any{"name": "http", "port": 8080}},
+	}
+}
+
+func endpoint(ip string, ready, serving, terminating bool) map[string]any {
+	return map[string]any{
+		"addresses":  []any{ip},
+		"conditions": map[string]any{"ready": ready, "serving": serving, "terminating": terminating},
+	}
+}
+
+func TestKubernetesController(t *testing.T) {
+	api, addr := newFakeAPIServer(t)
+	api.set(servicePath, map[string]any{
+		"metadata": meta("default", "web", "1"),
+		"spec":     map[string]any{"ports": []any{map[string]any{"name": "http", "port": 80}}},
+	})
+	api.set(ingressesPath,
+		ingress("web", "balto",
+			rule("web.example.com", "/", "Prefix", "web", 80),
+			rule("web.example.com", "/status", "Exact", "web", "http"),
+			rule("*.apps.example.com", "/", "Prefix", "web", 80),
+			rule("", "/", "Prefix", "web", 80),
+		),
+		ingress("other", "nginx", rule("other.example.com", "/", "Prefix", "web", 80)),
+	)
+	api.set(endpointSlicePath, endpointSlice("web-abc", "web", "1",
+		endpoint("10.1.0.1", true, true, false),
+		endpoint("10.1.0.2", false, true, true),
+		endpoint("10.1.0.3", false, false, false),
+	))
+
+	swaps := make(chan *router.Router, 8)
+	k, err := NewKubernetes(KubernetesConfig{
+		APIServer:    addr,
+		Token:        "secret",
+		IngressClass: "balto",
+		Bus:          pubsub.New(),
+		Swap:         func(rt *router.Router) { swaps <- rt },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = k.Router().Stop() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go k.Run(ctx)
+
+	var rt *router.Router
+	select {
+	case rt = <-swaps:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for routes")
+	}
+
+	want := []string{"*.apps.example.com/*", "*/*", "web.example.com/*", "web.example.com/status"}
+	if got := routeKeys(rt); !equal(got, want) {
+		t.Fatalf("unexpected routes %v", got)
+	}
+	want = []string{"web.example.com-http://10.1.0.1:8080", "web.example.com-http://10.1.0.2:8080 (draining)"}
+	if got := routePool(t, rt, "web.example.com/*"); !equal(got, want) {
+		t.Errorf("unexpected backends %v", got)
+	}
+	if route, _, ok := rt.Lookup("web.example.com", "/anything"); !ok || route.BackendPath("/anything", nil) != "/anything" {
+		t.Errorf("expected prefix route preserving the path, got %+v (found %v)", route, ok)
+	}
+	if route, _, ok := rt.Lookup("a.apps.example.com", "/"); !ok || route.Pool.Config().ServiceName != "*.apps.example.com/*" {
+		t.Errorf("expected the wildcard rule to serve a.apps.example.com, got %+v (found %v)", route, ok)
+	}
+	if route, _, ok := rt.Lookup("10.0.0.1", "/"); !ok || route.Pool.Config().ServiceName != "*/*" {
+		t.Errorf("expected the hostless rule to serve unknown hosts, got %+v (found %v)", route, ok)
+	}
+
+	t.Run("EndpointSlice updates reconcile pools", func(t *testing.T) {
+		api.send(t, endpointSlicePath, "MODIFIED", endpointSlice("web-abc", "web", "2",
+			endpoint("10.1.0.1", true, true, false),
+			endpoint("10.1.0.4", true, true, false),
+		))
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			got := routePool(t, k.Router(), "web.example.com/*")
+			if strings.Contains(strings.Join(got, ","), "10.1.0.4") {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("endpoints not updated: %v", got)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+
+	t.Run("Deleted Ingress removes routes", func(t *testing.T) {
+		api.send(t, ingressesPath, "DELETED", ingress("web", "balto"))
+		select {
+		case rt := <-swaps:
+			if got := routeKeys(rt); len(got) != 0 {
+				t.Errorf("expected no routes, got %v", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for route removal")
+		}
+	})
+}
+
+func TestKubernetesWatchErrorWaits(t *testing.T) {
+	api, addr := newFakeAPIServer(t)
+	k, err := NewKubernetes(KubernetesConfig{
+		APIServer:     addr,
+		Token:         "secret",
+		RetryInterval: 300 * time.Millisecond,
+		Bus:           pubsub.New(),
+		Swap:          func(*router.Router) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = k.Router().Stop() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go k.Run(ctx)
+
+	waitListed := func(n int, within time.Duration) {
+		t.Helper()
+		deadline := time.Now().Add(within)
+		for api.listed(ingressesPath) < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d lists, got %d", n, api.listed(ingressesPath))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitListed(1, 2*time.Second)
+
+	// 410 Gone: the watch cannot resume and the cache is listed again, but
+	// only after RetryInterval.
+	start := time.Now()
+	api.send(t, ingressesPath, "ERROR", map[string]any{"code": 410})
+	waitListed(2, 2*time.Second)
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("re-listed after %v, expected to wait RetryInterval", d)
+	}
+}
+
+func TestIngressHealthAnnotations(t *testing.T) {
+	var ingresses []k8sIngress
+	b, _ := json.Marshal([]any{
+		map[string]any{
+			"metadata": meta("default", "ping", "1"),
+			"spec":     map[string]any{"rules": []any{rule("ping.example.com", "/", "Prefix", "web", 80)}},
+		},
+		ingress("web", "", rule("web.example.com", "/", "Prefix", "web", 80)),
+	})
+	if err := json.Unmarshal(b, &ingresses); err != nil {
+		t.Fatal(err)
+	}
+	ingresses[0].Metadata.Annotations = map[string]string{AnnotationHealthType: "icmp"}
+	ingresses[1].Metadata.Annotations = map[string]string{AnnotationHealthType: "tcp"}
+	var services []k8sService
+	b, _ = json.Marshal([]any{map[string]any{
+		"metadata": meta("default", "web", "1"),
+		"spec":     map[string]any{"ports": []any{map[string]any{"name": "http", "port": 80}}},
+	}})
+	if err := json.Unmarshal(b, &services); err != nil {
+		t.Fatal(err)
+	}
+
+	routes, errs := kubernetesRoutes(ingresses, services, nil, "", nil)
+	if len(routes) != 1 || routes["web.example.com/*"].pool.ProbeType != "tcp" {
+		t.Errorf("expected only the web route with a tcp probe, got %+v", routes)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "ingress default/ping: "+AnnotationHealthType) ||
+		!strings.Contains(errs[0].Error(), `unknown health type "icmp"`) {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestIngressPath(t *testing.T) {
+	cases := []struct{ path, typ, want string }{
+		{"/", "Prefix", "/*"},
+		{"/api/", "Prefix", "/api/*"},
+		{"/api", "ImplementationSpecific", "/api/*"},
+		{"/api", "Exact", "/api"},
+	}
+	for _, c := range cases {
+		got, err := ingressPath(c.path, c.typ)
+		if err != nil || got != c.want {
+			t.Errorf("ingressPath(%q, %q) = %q, %v; want %q", c.path, c.typ, got, err, c.want)
+		}
+	}
+	if _, err := ingressPath("api", "Prefix"); err == nil {
+		t.Error("expected error for relative path")
+	}
+}
@@ -0,0 +1,226 @@
+package discovery
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/health"
+	"github.com/diabeney/balto/internal/router"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+// routeSpec is a route as reported by a provider that manages whole routes,
+// not just the backends of an existing pool.
+type routeSpec struct {
+	host    router.Host
+	path    string
+	pool    backendpool.PoolConfig
+	targets []Target
+
+	rewrite    *router.Rewrite
+	headers    *router.Headers
+	hostHeader router.HostHeader
+	match      *router.Match
+	redirect   *router.Redirect
+	response   *router.DirectResponse
+}
+
+func (r routeSpec) key() string { return router.MatchKey(r.host, r.path, r.match) }
+
+func (r routeSpec) pattern() string { return router.PatternKey(r.host, r.path, r.match) }
+
+// direct reports whether the route answers requests itself and so has no
+// targets or pool.
+func (r routeSpec) direct() bool { return r.redirect != nil || r.response != nil }
+
+// sameRoute reports whether b can keep the router's route for a.
+func sameRoute(a, b routeSpec) bool {
+	return samePool(a.pool, b.pool) && a.path == b.path &&
+		a.rewrite.String() == b.rewrite.String() &&
+		a.headers.String() == b.headers.String() && a.hostHeader == b.hostHeader &&
+		a.redirect.String() == b.redirect.String() &&
+		a.response.String() == b.response.String()
+}
+
+// SharedRouter is the live router of the providers that manage whole
+// routes. Each provider adds and removes only its own routes, one provider
+// at a time, so the files, Docker and Kubernetes providers can run side by
+// side without one swap undoing another's.
+type SharedRouter struct {
+	base *router.Router
+	swap func(*router.Router)
+
+	mu      sync.Mutex
+	current *router.Router
+}
+
+// NewSharedRouter returns a SharedRouter that starts from base, nil for an
+// empty router, and installs every change with swap, as FileConfig.Swap.
+func NewSharedRouter(base *router.Router, swap func(*router.Router)) *SharedRouter {
+	if base == nil {
+		base = router.NewRouter()
+	}
+	return &SharedRouter{base: base, swap: swap, current: base}
+}
+
+// Router returns the live router.
+func (s *SharedRouter) Router() *router.Router {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// routeTable tracks the routes a provider added to a SharedRouter and keeps
+// a Syncer per route for backend changes.
+type routeTable struct {
+	shared       *SharedRouter
+	source       string // provider, for ErrorEvents
+	bus          *pubsub.Bus
+	drainTimeout time.Duration
+
+	applied  map[string]*appliedRoute // by route key
+	rejected map[string]string        // routes owned by another provider, by route key
+}
+
+type appliedRoute struct {
+	route  routeSpec
+	syncer *Syncer
+}
+
+// newRouteTable returns a table on shared, or on a SharedRouter of its own
+// built from base and swap when shared is nil.
+func newRouteTable(source string, shared *SharedRouter, base *router.Router, swap func(*router.Router), bus *pubsub.Bus, drainTimeout time.Duration) *routeTable {
+	if shared == nil {
+		shared = NewSharedRouter(base, swap)
+	}
+	return &routeTable{
+		shared:       shared,
+		source:       source,
+		bus:          bus,
+		drainTimeout: drainTimeout,
+		applied:      make(map[string]*appliedRoute),
+		rejected:     make(map[string]string),
+	}
+}
+
+// basePatterns returns the pattern keys of the base router's routes, which
+// providers may neither redefine nor shadow ambiguously.
+func (t *routeTable) basePatterns() map[string]bool {
+	return routePatterns(t.shared.base)
+}
+
+func routePatterns(rt *router.Router) map[string]bool {
+	patterns := make(map[string]bool)
+	for _, ri := range rt.Routes() {
+		patterns[router.PatternKey(ri.Host, ri.Route.Prefix, ri.Route.Match)] = true
+	}
+	return patterns
+}
+
+// apply makes desired, keyed by route key, the provider's route set. Backend
+// changes are reconciled into the existing pools. Added, removed and
+// re-configured routes are applied one by one to the shared router's current
+// router, never a copy of the provider's own, so unchanged routes and those of
+// the base router and other providers keep their pools. A route another
+// provider already defines is left out and reported; it is tried again on the
+// next apply. A route is only created once it has targets, unless it is a
+// redirect or direct response, while an existing route that loses them all is
+// kept with its backends drained.
+func (t *routeTable) apply(desired map[string]routeSpec) {
+	for key, r := range desired {
+		if _, ok := t.applied[key]; !ok && len(r.targets) == 0 && !r.direct() {
+			delete(desired, key)
+		}
+	}
+
+	t.shared.mu.Lock()
+	prev := t.shared.current
+	next := prev
+	var displaced []*health.Healthchecker
+	for key, a := range t.applied {
+		r, ok := desired[key]
+		if ok && sameRoute(a.route, r) {
+			continue
+		}
+		var hc *health.Healthchecker
+		next, hc = next.RemoveMatch(a.route.host, a.route.path, a.route.match)
+		if hc != nil {
+			displaced = append(displaced, hc)
+		}
+		delete(t.applied, key)
+		if ok && len(r.targets) == 0 && !r.direct() {
+			delete(desired, key)
+		}
+	}
+
+	added := make(map[string]routeSpec)
+	rejected := make(map[string]string)
+	for key, r := range desired {
+		if _, ok := t.applied[key]; ok {
+			continue
+		}
+		if err := next.Conflict(r.host, r.path, r.match); err != nil {
+			rejected[key] = err.Error()
+			if t.rejected[key] != err.Error() {
+				publishError(t.bus, t.source, "", fmt.Errorf("route %s: %w", key, err))
+			}
+			continue
+		}
+		added[key] = r
+		urls := make([]*url.URL, len(r.targets))
+		for i, tg := range r.targets {
+			urls[i] = tg.URL
+		}
+		cfg := r.pool
+		next = next.AddWithOptions(r.host, r.path, urls, router.RouteOptions{
+			Pool: &cfg, Rewrite: r.rewrite, Headers: r.headers, HostHeader: r.hostHeader, Match: r.match,
+			Redirect: r.redirect, Response: r.response,
+		})
+	}
+
+	if next != prev {
+		pools := make(map[string]*backendpool.Pool)
+		for _, ri := range next.Routes() {
+			pools[ri.Key] = ri.Route.Pool
+		}
+		for key, r := range added {
+			pool, ok := pools[key]
+			if !ok {
+				continue // rejected by the router
+			}
+			a := &appliedRoute{route: r}
+			if pool != nil {
+				a.syncer = NewSyncer(pool, t.drainTimeout)
+			}
+			t.applied[key] = a
+		}
+
+		next.Start()
+		t.shared.current = next
+		t.shared.swap(next)
+	}
+	t.shared.mu.Unlock()
+	t.rejected = rejected
+	for _, hc := range displaced {
+		_ = hc.Stop()
+	}
+
+	for key, r := range desired {
+		a := t.applied[key]
+		if a == nil {
+			continue // rejected by the router or left to another provider
+		}
+		a.route = r
+		if a.syncer != nil {
+			a.syncer.Apply(r.targets)
+		}
+	}
+}
+
+func samePool(a, b backendpool.PoolConfig) bool {
+	return a.ProbeType == b.ProbeType && a.ProbePath == b.ProbePath &&
+		a.ProbeInterval == b.ProbeInterval && a.Timeout == b.Timeout
+}
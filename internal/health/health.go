@@ -2,18 +2,41 @@ package health
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
-	"math/rand"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/diabeney/balto/internal/core"
 	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
+// TopicProbe carries a ProbeResult for every probe the checker runs.
+const TopicProbe pubsub.Topic = "health.probe"
+
+type ProbeResult struct {
+	Time    time.Time     `json:"time"`
+	Pool    string        `json:"pool"`
+	Backend string        `json:"backend"`
+	URL     string        `json:"url"`
+	Healthy bool          `json:"healthy"`
+	Status  int           `json:"status,omitempty"` // HTTP status code, zero for non-HTTP probes or transport errors
+	Latency time.Duration `json:"latency_ns"`
+	Error   string        `json:"error,omitempty"`
+}
+
+func (r ProbeResult) String() string {
+	if r.Healthy {
+		return fmt.Sprintf("[%s] probe %s ok in %s", r.Pool, r.Backend, r.Latency)
+	}
+	return fmt.Sprintf("[%s] probe %s failed in %s: %s", r.Pool, r.Backend, r.Latency, r.Error)
+}
+
 func CheckBaltoHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -23,6 +46,7 @@ func CheckBaltoHealth(w http.ResponseWriter, r *http.Request) {
 type Healthchecker struct {
 	pool     *backendpool.Pool
 	interval time.Duration
+	schedule schedule
 	timeout  time.Duration
 	client   *http.Client
 	mu       sync.Mutex
@@ -31,6 +55,20 @@ type Healthchecker struct {
 	probes   map[string]context.CancelFunc
 	wg       sync.WaitGroup
 	started  bool
+
+	grpcClient *grpcClient
+
+	expectMu  sync.Mutex
+	expect    *httpExpectation
+	tcpExpect *tcpExpectation
+
+	histMu      sync.Mutex
+	history     map[string]*probeHistory
+	historySize int
+
+	sweepMu sync.Mutex
+	pending map[string]struct{} // backends still waiting for their first probe
+	swept   atomic.Bool
 }
 
 func New(pool *backendpool.Pool) *Healthchecker {
@@ -44,11 +82,11 @@ func New(pool *backendpool.Pool) *Healthchecker {
 		timeout = 50 * time.Millisecond
 	}
 
-	interval := time.Duration(cfg.ProbeInterval) * time.Millisecond
-	if interval <= 0 {
-		interval = 1 * time.Second
-	} else if interval < 100*time.Millisecond {
-		interval = 100 * time.Millisecond
+	sched := newSchedule(cfg)
+
+	historySize := cfg.ProbeHistorySize
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
 	}
 
 	transport := &http.Transport{
@@ -62,19 +100,34 @@ func New(pool *backendpool.Pool) *Healthchecker {
 		IdleConnTimeout:       90 * time.Second,
 		MaxIdleConns:          100,
 		MaxIdleConnsPerHost:   10,
+		TLSClientConfig:       probeTLSConfig(cfg),
 	}
 
 	return &Healthchecker{
-		pool:     pool,
-		interval: interval,
-		timeout:  timeout,
+		pool:        pool,
+		interval:    sched.healthy,
+		schedule:    sched,
+		timeout:     timeout,
+		grpcClient:  newGRPCClient(cfg, timeout),
+		history:     make(map[string]*probeHistory),
+		historySize: historySize,
 		client: &http.Client{
 			Timeout:   timeout,
 			Transport: transport,
+			// Status expectations apply to the backend's own answer, not to
+			// wherever it redirects.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
 	}
 }
 
+func (h *Healthchecker) closeIdleConnections() {
+	h.client.CloseIdleConnections()
+	h.grpcClient.closeIdleConnections()
+}
+
 func (h *Healthchecker) Start() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -91,7 +144,11 @@ func (h *Healthchecker) Start() {
 	}()
 }
 
+// Stop stops probing and closes the checker's idle probe connections, so a
+// router replaced on reload does not leave them open.
 func (h *Healthchecker) Stop() error {
+	defer h.closeIdleConnections()
+
 	h.mu.Lock()
 	if !h.started {
 		h.mu.Unlock()
@@ -159,6 +216,8 @@ func (h *Healthchecker) reconcile() {
 			delete(h.probes, id)
 		}
 	}
+	h.pruneHistory(currentIDs)
+	h.trackSweep(currentIDs)
 
 	for id, b := range currentIDs {
 		if _, exists := h.probes[id]; !exists {
@@ -188,8 +247,10 @@ func (h *Healthchecker) startProbeForBackendLocked(b *core.Backend) {
 }
 
 func (h *Healthchecker) probeLoop(ctx context.Context, b *core.Backend) {
-	delay := h.interval + h.jitter()
-	timer := time.NewTimer(delay)
+	// The first probe only waits for jitter so the initial sweep, which gates
+	// readiness, finishes quickly.
+	var st probeState
+	timer := time.NewTimer(jitter(h.schedule.healthy))
 	defer timer.Stop()
 
 	for {
@@ -200,56 +261,132 @@ func (h *Healthchecker) probeLoop(ctx context.Context, b *core.Backend) {
 			if !b.IsDraining() {
 				h.runProbe(ctx, b)
 			}
-			timer.Reset(h.interval + h.jitter())
+			timer.Reset(h.nextDelay(b, &st))
 		}
 	}
 }
 
+func (h *Healthchecker) nextDelay(b *core.Backend, st *probeState) time.Duration {
+	d := h.schedule.next(b, st, time.Now())
+	return d + jitter(d)
+}
+
 func (h *Healthchecker) runProbe(ctx context.Context, b *core.Backend) {
 	cfg := h.pool.Config()
-	probePath := cfg.ProbePath
 
-	scheme := b.URL.Scheme
-	if scheme == "http" || scheme == "https" {
-		h.probeHTTP(ctx, b, probePath)
-	} else {
-		h.probeTCP(ctx, b)
+	start := time.Now()
+	var res ProbeResult
+	switch probeType(cfg, b) {
+	case ProbeHTTP:
+		res = h.probeHTTP(ctx, b, cfg)
+	case ProbeGRPC:
+		res = h.probeGRPC(ctx, b, cfg)
+	case ProbeTCP:
+		res = h.probeTCP(ctx, b, cfg)
+	case ProbeTLS:
+		res = h.probeTLS(ctx, b, cfg)
+	default:
+		res = ProbeResult{Error: fmt.Sprintf("invalid probe config: unknown probe type %q", cfg.ProbeType)}
+	}
+
+	// A probe interrupted by Stop says nothing about the backend.
+	if ctx.Err() != nil {
+		return
 	}
+
+	res.Time = start
+	res.Pool = cfg.ServiceName
+	res.Backend = b.ID
+	res.URL = b.URL.String()
+	res.Latency = time.Since(start)
+	h.report(b, res)
 }
 
-func (h *Healthchecker) probeHTTP(ctx context.Context, b *core.Backend, path string) {
-	probeURL := *b.URL
-	probeURL.Path = singleJoin(probeURL.Path, path)
+// Probe types accepted in PoolConfig.ProbeType.
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeTLS  = "tls"
+	ProbeGRPC = "grpc"
+)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
-	if err != nil {
-		log.Printf("Error creating request for %s: %v", probeURL.String(), err)
-		return
+func probeType(cfg *backendpool.PoolConfig, b *core.Backend) string {
+	if cfg.ProbeType != "" {
+		return strings.ToLower(cfg.ProbeType)
+	}
+	switch b.URL.Scheme {
+	case "http", "https":
+		return ProbeHTTP
+	case "grpc", "grpcs":
+		return ProbeGRPC
+	default:
+		return ProbeTCP
 	}
+}
 
-	resp, err := h.client.Do(req)
-	if err != nil {
-		h.pool.MarkUnhealthy(b)
-		return
+func probeTLSConfig(cfg *backendpool.PoolConfig) *tls.Config {
+	return &tls.Config{
+		ServerName:         cfg.ProbeTLSServerName,
+		InsecureSkipVerify: cfg.ProbeTLSInsecureSkipVerify, //nolint:gosec // opt-in per pool for self-signed backends
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 400 {
+func (h *Healthchecker) report(b *core.Backend, res ProbeResult) {
+	if res.Healthy {
 		h.pool.MarkHealthy(b)
 	} else {
 		h.pool.MarkUnhealthy(b)
 	}
+	h.record(res)
+	h.markProbed(res.Backend)
+	h.pool.Bus().Publish(TopicProbe, res)
 }
 
-func (h *Healthchecker) probeTCP(ctx context.Context, b *core.Backend) {
-	d := net.Dialer{Timeout: h.timeout}
-	conn, err := d.DialContext(ctx, "tcp", b.URL.Host)
-	if err != nil {
-		h.pool.MarkUnhealthy(b)
+// Swept reports whether every backend known when the checker started has been
+// probed at least once, so pool health reflects probe results rather than the
+// optimistic initial state.
+func (h *Healthchecker) Swept() bool {
+	return h.swept.Load()
+}
+
+func (h *Healthchecker) trackSweep(current map[string]*core.Backend) {
+	if h.swept.Load() {
+		return
+	}
+	h.sweepMu.Lock()
+	defer h.sweepMu.Unlock()
+	if h.pending == nil {
+		h.pending = make(map[string]struct{}, len(current))
+		for id, b := range current {
+			// Draining backends are never probed.
+			if !b.IsDraining() {
+				h.pending[id] = struct{}{}
+			}
+		}
+	}
+	for id := range h.pending {
+		if b, ok := current[id]; !ok || b.IsDraining() {
+			delete(h.pending, id)
+		}
+	}
+	if len(h.pending) == 0 {
+		h.swept.Store(true)
+	}
+}
+
+func (h *Healthchecker) markProbed(id string) {
+	if h.swept.Load() {
+		return
+	}
+	h.sweepMu.Lock()
+	defer h.sweepMu.Unlock()
+	if h.pending == nil {
 		return
 	}
-	conn.Close()
-	h.pool.MarkHealthy(b)
+	delete(h.pending, id)
+	if len(h.pending) == 0 {
+		h.swept.Store(true)
+	}
 }
 
 func singleJoin(a, b string) string {
@@ -267,14 +404,3 @@ func singleJoin(a, b string) string {
 	}
 	return a + b
 }
-
-func (h *Healthchecker) jitter() time.Duration {
-	if h.interval <= 0 {
-		return 0
-	}
-	max := h.interval / 5
-	if max <= 0 {
-		max = 10 * time.Millisecond
-	}
-	return time.Duration(rand.Int63n(int64(max)))
-}
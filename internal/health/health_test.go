@@ -1,6 +1,7 @@
 package health
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/diabeney/balto/internal/core"
 	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
 type mockBalancer struct{}
@@ -118,3 +120,170 @@ func TestHealthchecker(t *testing.T) {
 		}
 	})
 }
+
+func TestHealthcheckerPublishesProbeResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	bus := pubsub.New()
+	sub := bus.Subscribe(16, TopicProbe)
+	defer sub.Close()
+
+	u, _ := url.Parse(srv.URL)
+	pool := backendpool.New(&backendpool.PoolConfig{
+		ServiceName:   "probe-events",
+		ProbePath:     "/",
+		ProbeInterval: 100,
+	}, &mockBalancer{})
+	pool.SetBus(bus)
+	pool.Add("p1", u, 1)
+
+	hc := New(pool)
+	hc.Start()
+	defer func() { _ = hc.Stop() }()
+
+	select {
+	case ev := <-sub.C():
+		res, ok := pubsub.As[ProbeResult](ev)
+		if !ok {
+			t.Fatalf("unexpected payload %T", ev.Data)
+		}
+		if res.Healthy || res.Status != http.StatusServiceUnavailable || res.Backend != "p1" || res.Pool != "probe-events" {
+			t.Errorf("unexpected probe result %+v", res)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for probe result")
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	cases := []struct {
+		in      string
+		ok, bad []int
+		wantErr bool
+	}{
+		{in: "", ok: []int{200, 302, 399}, bad: []int{199, 400, 500}},
+		{in: "200-299,301", ok: []int{200, 204, 301}, bad: []int{300, 302, 404}},
+		{in: " 503 ", ok: []int{503}, bad: []int{200}},
+		{in: "abc", wantErr: true},
+		{in: "300-200", wantErr: true},
+		{in: "200-700", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			ranges, err := parseStatusRanges(c.in)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q", c.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			e := &httpExpectation{statuses: ranges}
+			for _, code := range c.ok {
+				if !e.statusOK(code) {
+					t.Errorf("%d should be accepted", code)
+				}
+			}
+			for _, code := range c.bad {
+				if e.statusOK(code) {
+					t.Errorf("%d should be rejected", code)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeHTTPExpectations(t *testing.T) {
+	var lastReq *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastReq = r
+		switch r.URL.Path {
+		case "/json":
+			_, _ = io.WriteString(w, `{"status":"UP","checks":[{"name":"db","ok":true}]}`)
+		case "/down":
+			_, _ = io.WriteString(w, `{"status":"DOWN"}`)
+		case "/moved":
+			http.Redirect(w, r, "/json", http.StatusMovedPermanently)
+		default:
+			_, _ = io.WriteString(w, "all systems go")
+		}
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	pool := backendpool.New(&backendpool.PoolConfig{}, &mockBalancer{})
+	pool.Add("b", u, 1)
+	b := pool.List()[0]
+	hc := New(pool)
+
+	cases := []struct {
+		name    string
+		cfg     backendpool.PoolConfig
+		healthy bool
+	}{
+		{"Default accepts 2xx", backendpool.PoolConfig{ProbePath: "/"}, true},
+		{"Status set rejects 200", backendpool.PoolConfig{ProbePath: "/", ProbeExpectStatus: "204"}, false},
+		{"Redirect is not followed", backendpool.PoolConfig{ProbePath: "/moved", ProbeExpectStatus: "301"}, true},
+		{"Body substring", backendpool.PoolConfig{ProbePath: "/", ProbeExpectBody: "systems go"}, true},
+		{"Body substring mismatch", backendpool.PoolConfig{ProbePath: "/", ProbeExpectBody: "maintenance"}, false},
+		{"Body regex", backendpool.PoolConfig{ProbePath: "/", ProbeExpectBodyRegex: `^all \w+ go$`}, true},
+		{"JSON field", backendpool.PoolConfig{ProbePath: "/json", ProbeExpectJSONField: "status", ProbeExpectJSONValue: "UP"}, true},
+		{"Nested JSON field", backendpool.PoolConfig{ProbePath: "/json", ProbeExpectJSONField: "checks.0.ok", ProbeExpectJSONValue: "true"}, true},
+		{"JSON field mismatch", backendpool.PoolConfig{ProbePath: "/down", ProbeExpectJSONField: "status", ProbeExpectJSONValue: "UP"}, false},
+		{"JSON body required", backendpool.PoolConfig{ProbePath: "/", ProbeExpectJSONField: "status", ProbeExpectJSONValue: "UP"}, false},
+		{"Invalid config fails", backendpool.PoolConfig{ProbePath: "/", ProbeMethod: "DELETE"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := c.cfg
+			res := hc.probeHTTP(context.Background(), b, &cfg)
+			if res.Healthy != c.healthy {
+				t.Errorf("expected healthy=%v, got %+v", c.healthy, res)
+			}
+		})
+	}
+
+	t.Run("Method, Host and headers are sent", func(t *testing.T) {
+		cfg := backendpool.PoolConfig{
+			ProbePath:    "/",
+			ProbeMethod:  "head",
+			ProbeHost:    "internal.example.com",
+			ProbeHeaders: map[string]string{"Authorization": "Bearer t0k3n"},
+		}
+		if res := hc.probeHTTP(context.Background(), b, &cfg); !res.Healthy {
+			t.Fatalf("expected healthy, got %+v", res)
+		}
+		if lastReq.Method != http.MethodHead {
+			t.Errorf("expected HEAD, got %s", lastReq.Method)
+		}
+		if lastReq.Host != "internal.example.com" {
+			t.Errorf("expected custom Host, got %s", lastReq.Host)
+		}
+		if lastReq.Header.Get("Authorization") != "Bearer t0k3n" {
+			t.Errorf("expected Authorization header, got %q", lastReq.Header.Get("Authorization"))
+		}
+	})
+}
+
+func TestValidateHTTPProbe(t *testing.T) {
+	if err := ValidateHTTPProbe(&backendpool.PoolConfig{ProbeExpectStatus: "200-299", ProbeMethod: "POST"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	invalid := []backendpool.PoolConfig{
+		{ProbeExpectStatus: "2xx"},
+		{ProbeExpectBodyRegex: "("},
+		{ProbeMethod: "PATCH"},
+		{ProbeExpectJSONField: "status"},
+	}
+	for _, cfg := range invalid {
+		cfg := cfg
+		if err := ValidateHTTPProbe(&cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package health
+
+import "github.com/diabeney/balto/internal/core"
+
+// DefaultHistorySize is the number of probe results kept per backend when the
+// pool does not set ProbeHistorySize.
+const DefaultHistorySize = 32
+
+// probeHistory is a fixed-size ring of the most recent probe results.
+type probeHistory struct {
+	entries []ProbeResult
+	next    int
+	full    bool
+}
+
+func newProbeHistory(size int) *probeHistory {
+	return &probeHistory{entries: make([]ProbeResult, size)}
+}
+
+func (r *probeHistory) add(res ProbeResult) {
+	r.entries[r.next] = res
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the results oldest first.
+func (r *probeHistory) list() []ProbeResult {
+	if !r.full {
+		return append([]ProbeResult(nil), r.entries[:r.next]...)
+	}
+	out := make([]ProbeResult, 0, len(r.entries))
+	out = append(out, r.entries[r.next:]...)
+	return append(out, r.entries[:r.next]...)
+}
+
+func (h *Healthchecker) record(res ProbeResult) {
+	h.histMu.Lock()
+	defer h.histMu.Unlock()
+	hist, ok := h.history[res.Backend]
+	if !ok {
+		hist = newProbeHistory(h.historySize)
+		h.history[res.Backend] = hist
+	}
+	hist.add(res)
+}
+
+// pruneHistory forgets backends that left the pool.
+func (h *Healthchecker) pruneHistory(current map[string]*core.Backend) {
+	h.histMu.Lock()
+	defer h.histMu.Unlock()
+	for id := range h.history {
+		if _, ok := current[id]; !ok {
+			delete(h.history, id)
+		}
+	}
+}
+
+// History returns the most recent probe results for a backend, oldest first.
+func (h *Healthchecker) History(backendID string) []ProbeResult {
+	h.histMu.Lock()
+	defer h.histMu.Unlock()
+	hist, ok := h.history[backendID]
+	if !ok {
+		return nil
+	}
+	return hist.list()
+}
@@ -0,0 +1,67 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+)
+
+func TestProbeHistoryRing(t *testing.T) {
+	r := newProbeHistory(3)
+	if got := r.list(); len(got) != 0 {
+		t.Fatalf("expected empty history, got %v", got)
+	}
+	for i := 1; i <= 5; i++ {
+		r.add(ProbeResult{Status: i})
+	}
+	got := r.list()
+	if len(got) != 3 || got[0].Status != 3 || got[1].Status != 4 || got[2].Status != 5 {
+		t.Errorf("expected last three results oldest first, got %v", got)
+	}
+}
+
+func TestHealthcheckerHistory(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	pool := backendpool.New(&backendpool.PoolConfig{
+		ProbePath:        "/",
+		ProbeInterval:    100,
+		ProbeHistorySize: 2,
+	}, &mockBalancer{})
+	pool.Add("h1", u, 1)
+
+	hc := New(pool)
+	hc.Start()
+	defer func() { _ = hc.Stop() }()
+
+	time.Sleep(500 * time.Millisecond)
+
+	probes := hc.History("h1")
+	if len(probes) != 2 {
+		t.Fatalf("expected history capped at 2, got %d", len(probes))
+	}
+	for _, p := range probes {
+		if p.Healthy || p.Status != http.StatusTeapot || p.Time.IsZero() || p.Error == "" {
+			t.Errorf("unexpected probe record %+v", p)
+		}
+	}
+	if !probes[0].Time.Before(probes[1].Time) {
+		t.Error("expected history ordered oldest first")
+	}
+
+	t.Run("Removed backends are forgotten", func(t *testing.T) {
+		pool.Remove("h1")
+		hc.reconcile()
+		if got := hc.History("h1"); got != nil {
+			t.Errorf("expected no history after removal, got %v", got)
+		}
+	})
+}
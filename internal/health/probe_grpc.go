@@ -0,0 +1,231 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/diabeney/balto/internal/core"
+	"github.com/diabeney/balto/internal/core/backendpool"
+)
+
+// gRPC Health Checking Protocol (grpc.health.v1.Health/Check), spoken over
+// plain HTTP/2 so the checker does not pull in a full gRPC stack. The request
+// and response messages are small enough to encode by hand.
+
+const grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+// Values of grpc.health.v1.HealthCheckResponse.ServingStatus.
+const (
+	grpcStatusUnknown        = 0
+	grpcStatusServing        = 1
+	grpcStatusNotServing     = 2
+	grpcStatusServiceUnknown = 3
+)
+
+var grpcServingStatus = map[uint64]string{
+	grpcStatusUnknown:        "UNKNOWN",
+	grpcStatusServing:        "SERVING",
+	grpcStatusNotServing:     "NOT_SERVING",
+	grpcStatusServiceUnknown: "SERVICE_UNKNOWN",
+}
+
+// grpcClient holds one HTTP/2 client for cleartext (h2c) backends and one for
+// TLS backends.
+type grpcClient struct {
+	plain *http.Client
+	tls   *http.Client
+}
+
+func newGRPCClient(cfg *backendpool.PoolConfig, timeout time.Duration) *grpcClient {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	plain := &http2.Transport{
+		AllowHTTP: true,
+		// h2c: dial plain TCP where the transport expects a TLS connection.
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ReadIdleTimeout: 30 * time.Second,
+	}
+
+	tlsCfg := probeTLSConfig(cfg)
+	tlsCfg.NextProtos = []string{http2.NextProtoTLS}
+	secure := &http2.Transport{
+		TLSClientConfig: tlsCfg,
+		ReadIdleTimeout: 30 * time.Second,
+	}
+
+	return &grpcClient{
+		plain: &http.Client{Timeout: timeout, Transport: plain},
+		tls:   &http.Client{Timeout: timeout, Transport: secure},
+	}
+}
+
+// closeIdleConnections closes the idle HTTP/2 connections of both clients.
+func (c *grpcClient) closeIdleConnections() {
+	c.plain.CloseIdleConnections()
+	c.tls.CloseIdleConnections()
+}
+
+func (h *Healthchecker) probeGRPC(ctx context.Context, b *core.Backend, cfg *backendpool.PoolConfig) ProbeResult {
+	client := h.grpcClient.plain
+	scheme := "http"
+	if b.URL.Scheme == "https" || b.URL.Scheme == "grpcs" {
+		client = h.grpcClient.tls
+		scheme = "https"
+	}
+	target := url.URL{Scheme: scheme, Host: b.URL.Host, Path: grpcHealthCheckPath}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(encodeHealthCheckRequest(cfg.ProbeGRPCService)))
+	if err != nil {
+		return ProbeResult{Error: fmt.Sprintf("creating request for %s: %v", target.String(), err)}
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Grpc-Timeout", strconv.FormatInt(h.timeout.Milliseconds(), 10)+"m")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	res := ProbeResult{Status: resp.StatusCode}
+	if resp.StatusCode != http.StatusOK {
+		res.Error = fmt.Sprintf("unexpected HTTP status %d", resp.StatusCode)
+		return res
+	}
+
+	// The body must be drained before trailers are available.
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err := grpcStatusError(resp); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if readErr != nil {
+		res.Error = fmt.Sprintf("reading response: %v", readErr)
+		return res
+	}
+
+	status, err := decodeHealthCheckResponse(body)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if status != grpcStatusServing {
+		name, ok := grpcServingStatus[status]
+		if !ok {
+			name = strconv.FormatUint(status, 10)
+		}
+		res.Error = "serving status " + name
+		return res
+	}
+
+	res.Healthy = true
+	return res
+}
+
+// grpcStatusError returns the gRPC error carried in the trailers, or in the
+// headers for trailers-only responses.
+func grpcStatusError(resp *http.Response) error {
+	code := resp.Trailer.Get("Grpc-Status")
+	msg := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code = resp.Header.Get("Grpc-Status")
+		msg = resp.Header.Get("Grpc-Message")
+	}
+	if code == "" {
+		return errors.New("missing grpc-status")
+	}
+	if code != "0" {
+		if msg, err := url.PathUnescape(msg); err == nil && msg != "" {
+			return fmt.Errorf("grpc-status %s: %s", code, msg)
+		}
+		return fmt.Errorf("grpc-status %s", code)
+	}
+	return nil
+}
+
+// encodeHealthCheckRequest frames a HealthCheckRequest{service} message.
+func encodeHealthCheckRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		msg = append(msg, 0x0A) // field 1, length-delimited
+		msg = binary.AppendUvarint(msg, uint64(len(service)))
+		msg = append(msg, service...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg))) // frame[0] = 0: not compressed
+	return append(frame, msg...)
+}
+
+// decodeHealthCheckResponse extracts the ServingStatus from a framed
+// HealthCheckResponse message.
+func decodeHealthCheckResponse(b []byte) (uint64, error) {
+	if len(b) < 5 {
+		return 0, errors.New("short gRPC response")
+	}
+	if b[0] != 0 {
+		return 0, errors.New("compressed gRPC response not supported")
+	}
+	n := binary.BigEndian.Uint32(b[1:5])
+	msg := b[5:]
+	if uint32(len(msg)) < n {
+		return 0, errors.New("truncated gRPC response")
+	}
+	msg = msg[:n]
+
+	// proto3 omits default values: an empty message means UNKNOWN.
+	var status uint64
+	for len(msg) > 0 {
+		tag, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return 0, errors.New("malformed gRPC response")
+		}
+		msg = msg[k:]
+		field, wire := tag>>3, tag&7
+
+		switch wire {
+		case 0: // varint
+			v, k := binary.Uvarint(msg)
+			if k <= 0 {
+				return 0, errors.New("malformed gRPC response")
+			}
+			msg = msg[k:]
+			if field == 1 {
+				status = v
+			}
+		case 1: // fixed64
+			if len(msg) < 8 {
+				return 0, errors.New("malformed gRPC response")
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			l, k := binary.Uvarint(msg)
+			if k <= 0 || uint64(len(msg)-k) < l {
+				return 0, errors.New("malformed gRPC response")
+			}
+			msg = msg[k+int(l):]
+		case 5: // fixed32
+			if len(msg) < 4 {
+				return 0, errors.New("malformed gRPC response")
+			}
+			msg = msg[4:]
+		default:
+			return 0, errors.New("malformed gRPC response")
+		}
+	}
+	return status, nil
+}
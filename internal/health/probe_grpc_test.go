@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+)
+
+// fakeGRPCHealth answers grpc.health.v1.Health/Check with the status
+// configured for the requested service.
+func fakeGRPCHealth(t *testing.T, statuses map[string]uint64) http.Handler {
+	t.Helper()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthCheckPath || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		service := ""
+		if len(body) > 5 && body[5] == 0x0A {
+			l, k := binary.Uvarint(body[6:])
+			service = string(body[6+k : 6+k+int(l)])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		status, ok := statuses[service]
+		if !ok {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown%20service")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		msg := binary.AppendUvarint([]byte{0x08}, status)
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		_, _ = w.Write(append(frame, msg...))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+func TestProbeGRPC(t *testing.T) {
+	statuses := map[string]uint64{
+		"":           grpcStatusServing,
+		"users.v1":   grpcStatusServing,
+		"billing.v1": grpcStatusNotServing,
+	}
+
+	h2cSrv := httptest.NewServer(h2c.NewHandler(fakeGRPCHealth(t, statuses), &http2.Server{}))
+	defer h2cSrv.Close()
+
+	tlsSrv := httptest.NewUnstartedServer(fakeGRPCHealth(t, statuses))
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	plainURL, _ := url.Parse(h2cSrv.URL)
+	plainURL.Scheme = "grpc"
+	tlsURL, _ := url.Parse(tlsSrv.URL)
+	tlsURL.Scheme = "grpcs"
+
+	cases := []struct {
+		name    string
+		url     *url.URL
+		service string
+		healthy bool
+	}{
+		{"Plaintext server health", plainURL, "", true},
+		{"Plaintext named service", plainURL, "users.v1", true},
+		{"Not serving", plainURL, "billing.v1", false},
+		{"Unknown service error status", plainURL, "ghost.v1", false},
+		{"TLS named service", tlsURL, "users.v1", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := &backendpool.PoolConfig{
+				ProbeGRPCService:           c.service,
+				ProbeTLSInsecureSkipVerify: true,
+			}
+			pool := backendpool.New(cfg, &mockBalancer{})
+			pool.Add("g", c.url, 1)
+			b := pool.List()[0]
+			hc := New(pool)
+
+			if got := probeType(pool.Config(), b); got != ProbeGRPC {
+				t.Fatalf("expected grpc probe for %s, got %s", c.url.Scheme, got)
+			}
+			res := hc.probeGRPC(context.Background(), b, pool.Config())
+			if res.Healthy != c.healthy {
+				t.Errorf("expected healthy=%v, got %+v", c.healthy, res)
+			}
+		})
+	}
+}
+
+func TestStopClosesGRPCConnections(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	// TLS rather than h2c: h2c hijacks the connection, hiding its close.
+	srv := httptest.NewUnstartedServer(fakeGRPCHealth(t, map[string]uint64{"": grpcStatusServing}))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "grpcs"
+	pool := backendpool.New(&backendpool.PoolConfig{ProbeTLSInsecureSkipVerify: true}, &mockBalancer{})
+	pool.Add("g", u, 1)
+	hc := New(pool)
+	if res := hc.probeGRPC(context.Background(), pool.List()[0], pool.Config()); !res.Healthy {
+		t.Fatalf("probe failed: %+v", res)
+	}
+
+	if err := hc.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("idle HTTP/2 connection still open after Stop")
+	}
+}
+
+func TestDecodeHealthCheckResponse(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      []byte
+		want    uint64
+		wantErr bool
+	}{
+		{"Empty message is UNKNOWN", []byte{0, 0, 0, 0, 0}, grpcStatusUnknown, false},
+		{"Serving", []byte{0, 0, 0, 0, 2, 0x08, 0x01}, grpcStatusServing, false},
+		{"Unknown fields are skipped", []byte{0, 0, 0, 0, 5, 0x12, 0x01, 'x', 0x08, 0x02}, grpcStatusNotServing, false},
+		{"Short frame", []byte{0, 0}, 0, true},
+		{"Truncated", []byte{0, 0, 0, 0, 4, 0x08}, 0, true},
+		{"Compressed", []byte{1, 0, 0, 0, 0}, 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeHealthCheckResponse(c.in)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if !c.wantErr && got != c.want {
+				t.Errorf("expected %d, got %d", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/diabeney/balto/internal/core"
+	"github.com/diabeney/balto/internal/core/backendpool"
+)
+
+// maxProbeBody bounds how much of a probe response is read for body and JSON
+// expectations.
+const maxProbeBody = 64 << 10
+
+type statusRange struct{ lo, hi int }
+
+// httpExpectation is the compiled form of a pool's HTTP probe settings.
+type httpExpectation struct {
+	spec httpExpectSpec
+
+	method   string
+	statuses []statusRange
+	bodyRe   *regexp.Regexp
+	jsonPath []string
+	err      error // set when the pool configuration is invalid
+}
+
+// httpExpectSpec holds the raw configuration an httpExpectation was compiled
+// from, so a changed pool configuration can be detected cheaply.
+type httpExpectSpec struct {
+	method, status, bodyRegex, jsonField string
+}
+
+func specOf(cfg *backendpool.PoolConfig) httpExpectSpec {
+	return httpExpectSpec{
+		method:    cfg.ProbeMethod,
+		status:    cfg.ProbeExpectStatus,
+		bodyRegex: cfg.ProbeExpectBodyRegex,
+		jsonField: cfg.ProbeExpectJSONField,
+	}
+}
+
+func compileExpectation(spec httpExpectSpec) *httpExpectation {
+	e := &httpExpectation{spec: spec}
+
+	e.method = strings.ToUpper(strings.TrimSpace(spec.method))
+	switch e.method {
+	case "":
+		e.method = http.MethodGet
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+	default:
+		e.err = fmt.Errorf("unsupported probe method %q", spec.method)
+		return e
+	}
+
+	if e.statuses, e.err = parseStatusRanges(spec.status); e.err != nil {
+		return e
+	}
+
+	if spec.bodyRegex != "" {
+		if e.bodyRe, e.err = regexp.Compile(spec.bodyRegex); e.err != nil {
+			e.err = fmt.Errorf("invalid probe body regex: %w", e.err)
+			return e
+		}
+	}
+
+	if spec.jsonField != "" {
+		e.jsonPath = strings.Split(spec.jsonField, ".")
+	}
+	return e
+}
+
+// parseStatusRanges parses a status expectation such as "200-299,301". An
+// empty string yields the default 200-399.
+func parseStatusRanges(s string) ([]statusRange, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return []statusRange{{200, 399}}, nil
+	}
+	var out []statusRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		loStr, hiStr, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(loStr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe status %q", part)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(strings.TrimSpace(hiStr)); err != nil {
+				return nil, fmt.Errorf("invalid probe status %q", part)
+			}
+		}
+		if lo < 100 || hi > 599 || lo > hi {
+			return nil, fmt.Errorf("invalid probe status %q", part)
+		}
+		out = append(out, statusRange{lo, hi})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("invalid probe status %q", s)
+	}
+	return out, nil
+}
+
+// ValidateHTTPProbe reports configuration errors in a pool's HTTP probe
+// settings that would otherwise only surface as failing probes.
+func ValidateHTTPProbe(cfg *backendpool.PoolConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if err := compileExpectation(specOf(cfg)).err; err != nil {
+		return err
+	}
+	if (cfg.ProbeExpectJSONField == "") != (cfg.ProbeExpectJSONValue == "") {
+		return fmt.Errorf("probe JSON field and value must be set together")
+	}
+	return nil
+}
+
+// expectationFor returns the compiled expectation for cfg, recompiling only
+// when the pool configuration changed.
+func (h *Healthchecker) expectationFor(cfg *backendpool.PoolConfig) *httpExpectation {
+	spec := specOf(cfg)
+	h.expectMu.Lock()
+	defer h.expectMu.Unlock()
+	if h.expect == nil || h.expect.spec != spec {
+		h.expect = compileExpectation(spec)
+	}
+	return h.expect
+}
+
+func (h *Healthchecker) probeHTTP(ctx context.Context, b *core.Backend, cfg *backendpool.PoolConfig) ProbeResult {
+	exp := h.expectationFor(cfg)
+	if exp.err != nil {
+		return ProbeResult{Error: "invalid probe config: " + exp.err.Error()}
+	}
+
+	probeURL := *b.URL
+	probeURL.Path = singleJoin(probeURL.Path, cfg.ProbePath)
+
+	var body io.Reader
+	if exp.method == http.MethodPost && cfg.ProbeBody != "" {
+		body = strings.NewReader(cfg.ProbeBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, exp.method, probeURL.String(), body)
+	if err != nil {
+		return ProbeResult{Error: fmt.Sprintf("creating request for %s: %v", probeURL.String(), err)}
+	}
+	for k, v := range cfg.ProbeHeaders {
+		req.Header.Set(k, v)
+	}
+	if cfg.ProbeHost != "" {
+		req.Host = cfg.ProbeHost
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	res := ProbeResult{Status: resp.StatusCode}
+	if !exp.statusOK(resp.StatusCode) {
+		res.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return res
+	}
+
+	if needsBody(cfg) {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if err != nil {
+			res.Error = fmt.Sprintf("reading body: %v", err)
+			return res
+		}
+		if err := exp.checkBody(cfg, data); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+	}
+
+	res.Healthy = true
+	return res
+}
+
+func needsBody(cfg *backendpool.PoolConfig) bool {
+	return cfg.ProbeExpectBody != "" || cfg.ProbeExpectBodyRegex != "" || cfg.ProbeExpectJSONField != ""
+}
+
+func (e *httpExpectation) statusOK(code int) bool {
+	for _, r := range e.statuses {
+		if code >= r.lo && code <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *httpExpectation) checkBody(cfg *backendpool.PoolConfig, body []byte) error {
+	if cfg.ProbeExpectBody != "" && !bytes.Contains(body, []byte(cfg.ProbeExpectBody)) {
+		return fmt.Errorf("body does not contain %q", cfg.ProbeExpectBody)
+	}
+	if e.bodyRe != nil && !e.bodyRe.Match(body) {
+		return fmt.Errorf("body does not match %q", e.bodyRe.String())
+	}
+	if len(e.jsonPath) > 0 {
+		got, err := jsonField(body, e.jsonPath)
+		if err != nil {
+			return err
+		}
+		if got != cfg.ProbeExpectJSONValue {
+			return fmt.Errorf("%s is %q, want %q", cfg.ProbeExpectJSONField, got, cfg.ProbeExpectJSONValue)
+		}
+	}
+	return nil
+}
+
+// jsonField extracts the value at path from a JSON document and renders it
+// as a string. Path elements index objects by key and arrays by position.
+func jsonField(body []byte, path []string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("body is not JSON: %v", err)
+	}
+
+	for _, key := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("JSON field %q not found", strings.Join(path, "."))
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("JSON field %q not found", strings.Join(path, "."))
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("JSON field %q not found", strings.Join(path, "."))
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case nil:
+		return "null", nil
+	case bool, json.Number:
+		return fmt.Sprint(val), nil
+	default:
+		// Objects and arrays compare by their compact JSON form.
+		out, _ := json.Marshal(val)
+		return string(out), nil
+	}
+}
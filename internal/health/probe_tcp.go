@@ -0,0 +1,179 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/diabeney/balto/internal/core"
+	"github.com/diabeney/balto/internal/core/backendpool"
+)
+
+// tcpExpectation is the compiled form of a pool's send/expect settings.
+type tcpExpectation struct {
+	regex string
+	re    *regexp.Regexp
+	err   error
+}
+
+func (h *Healthchecker) tcpExpectationFor(cfg *backendpool.PoolConfig) *tcpExpectation {
+	h.expectMu.Lock()
+	defer h.expectMu.Unlock()
+	if h.tcpExpect == nil || h.tcpExpect.regex != cfg.ProbeExpectRegex {
+		e := &tcpExpectation{regex: cfg.ProbeExpectRegex}
+		if e.regex != "" {
+			if e.re, e.err = regexp.Compile(e.regex); e.err != nil {
+				e.err = fmt.Errorf("invalid probe expect regex: %w", e.err)
+			}
+		}
+		h.tcpExpect = e
+	}
+	return h.tcpExpect
+}
+
+func (h *Healthchecker) probeTCP(ctx context.Context, b *core.Backend, cfg *backendpool.PoolConfig) ProbeResult {
+	exp := h.tcpExpectationFor(cfg)
+	if exp.err != nil {
+		return ProbeResult{Error: "invalid probe config: " + exp.err.Error()}
+	}
+
+	d := net.Dialer{Timeout: h.timeout}
+	conn, err := d.DialContext(ctx, "tcp", b.URL.Host)
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	defer conn.Close()
+
+	if err := h.sendExpect(conn, cfg, exp); err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	return ProbeResult{Healthy: true}
+}
+
+func (h *Healthchecker) probeTLS(ctx context.Context, b *core.Backend, cfg *backendpool.PoolConfig) ProbeResult {
+	exp := h.tcpExpectationFor(cfg)
+	if exp.err != nil {
+		return ProbeResult{Error: "invalid probe config: " + exp.err.Error()}
+	}
+
+	tlsCfg := probeTLSConfig(cfg)
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = b.URL.Hostname()
+	}
+	d := tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: h.timeout},
+		Config:    tlsCfg,
+	}
+	raw, err := d.DialContext(ctx, "tcp", b.URL.Host)
+	if err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	conn := raw.(*tls.Conn)
+	defer conn.Close()
+
+	if err := checkCertExpiry(conn.ConnectionState(), cfg.ProbeTLSMinValidDays); err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+
+	if err := h.sendExpect(conn, cfg, exp); err != nil {
+		return ProbeResult{Error: err.Error()}
+	}
+	return ProbeResult{Healthy: true}
+}
+
+func checkCertExpiry(state tls.ConnectionState, minValidDays int) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+	leaf := state.PeerCertificates[0]
+	left := time.Until(leaf.NotAfter)
+	if left <= 0 {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if minValidDays > 0 && left < time.Duration(minValidDays)*24*time.Hour {
+		return fmt.Errorf("certificate expires at %s, within %d days", leaf.NotAfter.UTC().Format(time.RFC3339), minValidDays)
+	}
+	return nil
+}
+
+// sendExpect writes the configured payload and waits for the expected reply,
+// all within the probe timeout. With nothing configured it is a no-op, so a
+// plain probe only checks that the connection (or handshake) succeeds.
+func (h *Healthchecker) sendExpect(conn net.Conn, cfg *backendpool.PoolConfig, exp *tcpExpectation) error {
+	if cfg.ProbeSend == "" && cfg.ProbeExpect == "" && exp.re == nil {
+		return nil
+	}
+	_ = conn.SetDeadline(time.Now().Add(h.timeout))
+
+	if cfg.ProbeSend != "" {
+		if _, err := io.WriteString(conn, cfg.ProbeSend); err != nil {
+			return fmt.Errorf("sending probe payload: %v", err)
+		}
+	}
+	if cfg.ProbeExpect == "" && exp.re == nil {
+		return nil
+	}
+
+	prefix := []byte(cfg.ProbeExpect)
+	buf := make([]byte, 0, 512)
+	chunk := make([]byte, 512)
+	for {
+		if matched, err := matchReply(buf, prefix, exp.re); matched || err != nil {
+			return err
+		}
+		if len(buf) >= maxProbeBody {
+			return fmt.Errorf("reply %q does not match", truncate(buf))
+		}
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if err != nil {
+			if matched, mErr := matchReply(buf, prefix, exp.re); matched || mErr != nil {
+				return mErr
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("timed out waiting for reply, got %q", truncate(buf))
+			}
+			return fmt.Errorf("reply %q does not match: %v", truncate(buf), err)
+		}
+	}
+}
+
+// matchReply reports whether buf satisfies the expectations. It returns an
+// error once the reply can no longer match, so the probe fails fast instead of
+// waiting for the timeout. An empty reply never matches, even a regex such as
+// ".*": an expectation requires the backend to answer.
+func matchReply(buf, prefix []byte, re *regexp.Regexp) (bool, error) {
+	if len(buf) == 0 {
+		return false, nil
+	}
+	if len(prefix) > 0 {
+		n := len(buf)
+		if n > len(prefix) {
+			n = len(prefix)
+		}
+		if !bytes.Equal(buf[:n], prefix[:n]) {
+			return false, fmt.Errorf("reply %q does not start with %q", truncate(buf), prefix)
+		}
+		if len(buf) < len(prefix) {
+			return false, nil
+		}
+	}
+	if re != nil && !re.Match(buf) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func truncate(b []byte) []byte {
+	if len(b) > 64 {
+		return b[:64]
+	}
+	return b
+}
@@ -0,0 +1,131 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+)
+
+// startLineServer runs a TCP server that answers every line with reply(line).
+// A nil reply keeps the connection open without answering.
+func startLineServer(t *testing.T, reply func(string) string) *url.URL {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if reply == nil {
+						continue
+					}
+					if _, err := conn.Write([]byte(reply(strings.TrimSpace(line)))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "redis", Host: ln.Addr().String()}
+}
+
+func TestProbeTCPSendExpect(t *testing.T) {
+	redis := startLineServer(t, func(cmd string) string {
+		if cmd == "PING" {
+			return "+PONG\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+	wedged := startLineServer(t, nil)
+
+	cases := []struct {
+		name    string
+		url     *url.URL
+		cfg     backendpool.PoolConfig
+		healthy bool
+	}{
+		{"Connect only", wedged, backendpool.PoolConfig{}, true},
+		{"Expected prefix", redis, backendpool.PoolConfig{ProbeSend: "PING\r\n", ProbeExpect: "+PONG"}, true},
+		{"Expected regex", redis, backendpool.PoolConfig{ProbeSend: "PING\r\n", ProbeExpectRegex: `^\+PO\w+`}, true},
+		{"Wrong reply", redis, backendpool.PoolConfig{ProbeSend: "HELLO\r\n", ProbeExpect: "+PONG"}, false},
+		{"Wedged backend times out", wedged, backendpool.PoolConfig{ProbeSend: "PING\r\n", ProbeExpect: "+PONG"}, false},
+		{"Invalid regex", redis, backendpool.PoolConfig{ProbeExpectRegex: "("}, false},
+		{"Empty-matching regex needs a reply", wedged, backendpool.PoolConfig{ProbeSend: "PING\r\n", ProbeExpectRegex: ".*"}, false},
+		{"Empty-matching regex with a reply", redis, backendpool.PoolConfig{ProbeSend: "PING\r\n", ProbeExpectRegex: ".*"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := c.cfg
+			cfg.Timeout = 100
+			pool := backendpool.New(&cfg, &mockBalancer{})
+			pool.Add("tcp", c.url, 1)
+			b := pool.List()[0]
+			hc := New(pool)
+
+			if got := probeType(pool.Config(), b); got != ProbeTCP {
+				t.Fatalf("expected tcp probe for %s, got %s", c.url.Scheme, got)
+			}
+			res := hc.probeTCP(context.Background(), b, pool.Config())
+			if res.Healthy != c.healthy {
+				t.Errorf("expected healthy=%v, got %+v", c.healthy, res)
+			}
+		})
+	}
+}
+
+func TestProbeTLSHandshake(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	cases := []struct {
+		name    string
+		cfg     backendpool.PoolConfig
+		healthy bool
+	}{
+		{"Untrusted certificate fails", backendpool.PoolConfig{}, false},
+		{"Skip verify passes", backendpool.PoolConfig{ProbeTLSInsecureSkipVerify: true, ProbeTLSMinValidDays: 30}, true},
+		{"Expiring certificate fails", backendpool.PoolConfig{ProbeTLSInsecureSkipVerify: true, ProbeTLSMinValidDays: 365 * 200}, false},
+		{"Send and expect over TLS", backendpool.PoolConfig{
+			ProbeTLSInsecureSkipVerify: true,
+			ProbeSend:                  "GET / HTTP/1.0\r\n\r\n",
+			ProbeExpect:                "HTTP/1.0 200",
+		}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := c.cfg
+			cfg.ProbeType = ProbeTLS
+			pool := backendpool.New(&cfg, &mockBalancer{})
+			pool.Add("tls", u, 1)
+			b := pool.List()[0]
+			hc := New(pool)
+
+			res := hc.probeTLS(context.Background(), b, pool.Config())
+			if res.Healthy != c.healthy {
+				t.Errorf("expected healthy=%v, got %+v", c.healthy, res)
+			}
+		})
+	}
+}
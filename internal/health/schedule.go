@@ -0,0 +1,111 @@
+package health
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/diabeney/balto/internal/core"
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/core/circuit"
+)
+
+const (
+	minProbeInterval     = 100 * time.Millisecond
+	defaultProbeInterval = 1 * time.Second
+	defaultMaxInterval   = 60 * time.Second
+)
+
+// schedule decides how long a probe loop waits between probes. Healthy
+// backends are probed at the regular interval; unhealthy ones, or ones whose
+// circuit is Open, at the unhealthy or recovery cadence, backing off
+// exponentially once they have been down longer than backoffAfter.
+type schedule struct {
+	healthy      time.Duration
+	unhealthy    time.Duration
+	recovery     time.Duration // zero when not configured
+	backoffAfter time.Duration // zero disables back-off
+	max          time.Duration
+}
+
+func newSchedule(cfg *backendpool.PoolConfig) schedule {
+	s := schedule{
+		healthy:      msInterval(cfg.ProbeInterval, defaultProbeInterval),
+		backoffAfter: time.Duration(cfg.ProbeBackoffAfter) * time.Millisecond,
+	}
+	s.unhealthy = msInterval(cfg.ProbeUnhealthyInterval, s.healthy)
+	if cfg.ProbeRecoveryInterval > 0 {
+		s.recovery = msInterval(cfg.ProbeRecoveryInterval, 0)
+	}
+	s.max = msInterval(cfg.ProbeMaxInterval, defaultMaxInterval)
+	if s.max < s.healthy {
+		s.max = s.healthy
+	}
+	return s
+}
+
+// msInterval converts a millisecond setting, falling back to def when unset
+// and clamping to minProbeInterval.
+func msInterval(ms int, def time.Duration) time.Duration {
+	d := time.Duration(ms) * time.Millisecond
+	if d <= 0 {
+		return def
+	}
+	if d < minProbeInterval {
+		return minProbeInterval
+	}
+	return d
+}
+
+// probeState is the per-backend state a probe loop carries between probes.
+type probeState struct {
+	downSince time.Time
+	backoff   time.Duration
+}
+
+// next returns the delay before the next probe of b, without jitter.
+func (s schedule) next(b *core.Backend, st *probeState, now time.Time) time.Duration {
+	healthy := b.IsHealthy()
+	open := b.Circuit != nil && b.Circuit.State() == circuit.Open
+	if healthy && !open {
+		st.downSince = time.Time{}
+		st.backoff = 0
+		return s.healthy
+	}
+
+	d := s.healthy
+	if !healthy {
+		d = s.unhealthy
+	}
+	if s.recovery > 0 {
+		d = s.recovery
+	}
+
+	if st.downSince.IsZero() {
+		st.downSince = now
+	}
+	if s.backoffAfter <= 0 || now.Sub(st.downSince) < s.backoffAfter {
+		return d
+	}
+
+	if st.backoff == 0 {
+		st.backoff = d
+	} else {
+		st.backoff *= 2
+	}
+	if st.backoff > s.max {
+		st.backoff = s.max
+	}
+	if st.backoff < d {
+		return d
+	}
+	return st.backoff
+}
+
+// jitter spreads probes of many backends so they don't fire in lockstep.
+func jitter(d time.Duration) time.Duration {
+	max := d / 5
+	if max <= 0 {
+		max = 10 * time.Millisecond
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/core/circuit"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
+)
+
+func TestNewScheduleDefaults(t *testing.T) {
+	s := newSchedule(&backendpool.PoolConfig{})
+	if s.healthy != time.Second || s.unhealthy != time.Second || s.recovery != 0 || s.backoffAfter != 0 || s.max != 60*time.Second {
+		t.Errorf("unexpected defaults %+v", s)
+	}
+
+	s = newSchedule(&backendpool.PoolConfig{ProbeInterval: 10, ProbeUnhealthyInterval: 5000, ProbeRecoveryInterval: 50})
+	if s.healthy != minProbeInterval || s.unhealthy != 5*time.Second || s.recovery != minProbeInterval {
+		t.Errorf("expected intervals clamped to %s, got %+v", minProbeInterval, s)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	u, _ := url.Parse("http://127.0.0.1:1")
+	cbCfg := circuit.Config{FailureThreshold: 1, Timeout: time.Hour, Bus: pubsub.New()}
+	now := time.Now()
+
+	s := schedule{
+		healthy:      10 * time.Second,
+		unhealthy:    2 * time.Second,
+		backoffAfter: 10 * time.Second,
+		max:          8 * time.Second,
+	}
+
+	t.Run("Healthy and unhealthy intervals", func(t *testing.T) {
+		b := backendpool.NewBackend("b", u, 1, cbCfg)
+		var st probeState
+		if d := s.next(b, &st, now); d != 10*time.Second {
+			t.Errorf("healthy: expected 10s, got %s", d)
+		}
+		b.SetHealthy(false)
+		if d := s.next(b, &st, now); d != 2*time.Second {
+			t.Errorf("unhealthy: expected 2s, got %s", d)
+		}
+		b.SetHealthy(true)
+		if d := s.next(b, &st, now); d != 10*time.Second || !st.downSince.IsZero() {
+			t.Errorf("recovered: expected 10s and reset state, got %s %+v", d, st)
+		}
+	})
+
+	t.Run("Recovery cadence while unhealthy or Open", func(t *testing.T) {
+		s := s
+		s.recovery = 500 * time.Millisecond
+
+		b := backendpool.NewBackend("b", u, 1, cbCfg)
+		var st probeState
+		b.Circuit.RecordFailure()
+		if b.Circuit.State() != circuit.Open {
+			t.Fatal("expected circuit to open")
+		}
+		if d := s.next(b, &st, now); d != 500*time.Millisecond {
+			t.Errorf("circuit open: expected 500ms, got %s", d)
+		}
+		b.SetHealthy(false)
+		if d := s.next(b, &st, now); d != 500*time.Millisecond {
+			t.Errorf("unhealthy: expected 500ms, got %s", d)
+		}
+	})
+
+	t.Run("Exponential back-off when down for long", func(t *testing.T) {
+		b := backendpool.NewBackend("b", u, 1, cbCfg)
+		b.SetHealthy(false)
+		var st probeState
+
+		if d := s.next(b, &st, now); d != 2*time.Second {
+			t.Fatalf("expected 2s before back-off, got %s", d)
+		}
+		later := now.Add(11 * time.Second)
+		var got []time.Duration
+		for i := 0; i < 4; i++ {
+			got = append(got, s.next(b, &st, later))
+		}
+		want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("expected back-off %v, got %v", want, got)
+			}
+		}
+
+		b.SetHealthy(true)
+		s.next(b, &st, later)
+		b.SetHealthy(false)
+		if d := s.next(b, &st, later); d != 2*time.Second {
+			t.Errorf("expected back-off reset after recovery, got %s", d)
+		}
+	})
+}
+
+func TestJitterBounds(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if j := jitter(time.Second); j < 0 || j >= 200*time.Millisecond {
+			t.Fatalf("jitter %s out of range", j)
+		}
+	}
+}
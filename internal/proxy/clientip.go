@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardingHeaders are the request headers that describe earlier hops.
+// They are dropped from requests that do not come from a trusted proxy.
+var forwardingHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"}
+
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8" for
+// Options.TrustedProxies. A bare IP trusts that address only.
+func ParseTrustedProxies(cidrs []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(cidrs))
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			out = append(out, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+func (p *Proxy) trusted(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range p.opts.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the connection's peer and the IP of the
+// client the request is for. A request from an untrusted peer is its own
+// client and loses its forwarding headers. From a trusted peer, the client
+// is the rightmost untrusted address of X-Forwarded-For, or of Forwarded
+// when there is no X-Forwarded-For; if every hop is trusted, the leftmost.
+func (p *Proxy) clientIP(req *http.Request) (peer, client netip.Addr) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	peer, err = netip.ParseAddr(host)
+	if err != nil || !p.trusted(peer) {
+		for _, h := range forwardingHeaders {
+			req.Header.Del(h)
+		}
+		return peer.Unmap(), peer.Unmap()
+	}
+
+	hops := forwardedFor(req.Header)
+	client = peer.Unmap()
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			break // unknown or obfuscated: the last trusted hop is all we know
+		}
+		client = ip.Unmap()
+		if !p.trusted(ip) {
+			break
+		}
+	}
+	return peer.Unmap(), client
+}
+
+// forwardedFor returns the client addresses a request's forwarding headers
+// list, the original client first.
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if xff := h.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, v := range xff {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, forwardedNode(strings.TrimSpace(hop)))
+			}
+		}
+		return hops
+	}
+	for _, v := range h.Values("Forwarded") {
+		for _, element := range strings.Split(v, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(name, "for") {
+					hop = forwardedNode(value)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// forwardedNode returns the IP of an RFC 7239 node such as `"[2001:db8::1]:80"`
+// or `192.0.2.1:80`, or the value as is when it holds no IP. Some proxies
+// write X-Forwarded-For entries with ports too.
+func forwardedNode(v string) string {
+	v = strings.Trim(v, `"`)
+	if strings.HasPrefix(v, "[") {
+		if end := strings.IndexByte(v, ']'); end != -1 {
+			return v[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return v
+}
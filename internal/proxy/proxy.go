@@ -5,19 +5,44 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/diabeney/balto/internal/core"
 	"github.com/diabeney/balto/internal/router"
 )
 
 type Proxy struct {
 	router *atomic.Pointer[router.Router]
 	client *http.Client
+	opts   Options
+}
+
+// Options configures the forwarding headers a Proxy trusts and adds beyond
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host.
+type Options struct {
+	// Forwarded appends an RFC 7239 Forwarded element (for, host, proto)
+	// to forwarded requests.
+	Forwarded bool
+	// Via, when set, is the pseudonym Balto adds to the Via header of
+	// requests and responses, e.g. "balto" gives "1.1 balto".
+	Via string
+	// TrustedProxies are the peers whose X-Forwarded-For and Forwarded
+	// headers are believed when deriving the client IP, see
+	// ParseTrustedProxies. Requests from any other peer have their
+	// forwarding headers discarded. The client IP is stored in the request
+	// context, see core.ClientIP.
+	TrustedProxies []netip.Prefix
 }
 
 func New(r *router.Router) *Proxy {
+	return NewWithOptions(r, Options{})
+}
+
+func NewWithOptions(r *router.Router, opts Options) *Proxy {
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
@@ -34,6 +59,7 @@ func New(r *router.Router) *Proxy {
 	}
 
 	p := &Proxy{
+		opts:   opts,
 		router: &atomic.Pointer[router.Router]{},
 		client: &http.Client{
 			Transport: transport,
@@ -53,6 +79,10 @@ func (p *Proxy) UpdateRouter(r *router.Router) {
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	peer, client := p.clientIP(req)
+	if client.IsValid() {
+		req = req.WithContext(core.WithClientIP(req.Context(), client))
+	}
 	ctx := req.Context()
 	rt := p.router.Load()
 
@@ -61,35 +91,36 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	route, params, ok := rt.Lookup(router.Host(req.Host), req.URL.Path)
+	route, params, ok := rt.Match(req)
 	if !ok {
 		http.Error(w, "route not found", http.StatusNotFound)
 		return
 	}
 
-	backend, err := route.NextBackend()
+	var clientIP, peerIP string
+	if client.IsValid() {
+		clientIP, peerIP = client.String(), peer.String()
+	}
+	hctx := router.HeaderContext{Request: req, Params: params, ClientIP: clientIP}
+
+	if route.Direct() {
+		route.ServeDirect(w, hctx)
+		return
+	}
+
+	backend, err := route.NextBackendFor(client)
 	if err != nil {
 		http.Error(w, "no backend available", http.StatusServiceUnavailable)
 		return
 	}
 
+	hctx.Backend = backend
+
 	backend.Meta.IncrActive()
 	defer backend.Meta.DecrActive()
 
 	outURL := *backend.URL
-	/*
-		Strip the matched prefix before forwarding.
-		Example:
-		External request:  /api/v1/users/123
-		Route prefix:      /api/v1
-		Backend receives:  /users/123
-		This allows the services to define routes without the public prefix.
-		For wildcard routes, we strip everything up to the wildcard.
-	*/
-	outURL.Path = stripPrefix(req.URL.Path, route.Prefix)
-	if outURL.Path == "" {
-		outURL.Path = "/"
-	}
+	outURL.Path = route.BackendPath(req.URL.Path, params)
 
 	outURL.RawQuery = req.URL.RawQuery
 
@@ -102,19 +133,28 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	copyHeaders(req.Header, outReq.Header)
 	removeHopHeaders(outReq.Header)
 
-	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-		appendHeader(outReq.Header, "X-Forwarded-For", clientIP)
+	if peerIP != "" {
+		appendHeader(outReq.Header, "X-Forwarded-For", peerIP)
 	}
 	appendHeader(outReq.Header, "X-Forwarded-Proto", schemeOf(req))
 	appendHeader(outReq.Header, "X-Forwarded-Host", req.Host)
+	if p.opts.Forwarded {
+		appendHeader(outReq.Header, "Forwarded", forwardedElement(peerIP, req.Host, schemeOf(req)))
+	}
+	if p.opts.Via != "" {
+		appendHeader(outReq.Header, "Via", viaElement(req.ProtoMajor, req.ProtoMinor, p.opts.Via))
+	}
 
 	if len(params) > 0 {
-		for k, v := range params {
-			appendHeader(outReq.Header, "X-Param-"+k, v)
+		for _, p := range params {
+			appendHeader(outReq.Header, "X-Param-"+p.Name, p.Value)
 		}
 	}
+	if route.Headers != nil {
+		route.Headers.Request.Apply(outReq.Header, hctx)
+	}
 
-	outReq.Host = backend.URL.Host
+	outReq.Host = route.UpstreamHost(req.Host, backend.URL)
 
 	// fmt.Printf("[PROXY] Sending %s request to internal service %v\n", outReq.Method, fmt.Sprintf("%s://%s%s", outReq.URL.Scheme, outReq.Host, req.URL.Path))
 	// start := time.Now()
@@ -122,7 +162,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		route.Pool.RecordFailure(backend)
 		http.Error(w, "bad gateway", http.StatusBadGateway)
-		fmt.Printf("[PROXY] %s %s -> failed: %v\n", req.Host, req.URL.Path, err)
+		fmt.Printf("[PROXY] %s %s from %s -> failed: %v\n", req.Host, req.URL.Path, clientIP, err)
 		return
 	}
 	defer resp.Body.Close()
@@ -135,6 +175,12 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	copyHeaders(resp.Header, w.Header())
 	removeHopHeaders(w.Header())
+	if p.opts.Via != "" {
+		appendHeader(w.Header(), "Via", viaElement(resp.ProtoMajor, resp.ProtoMinor, p.opts.Via))
+	}
+	if route.Headers != nil {
+		route.Headers.Response.Apply(w.Header(), hctx)
+	}
 	w.WriteHeader(resp.StatusCode)
 
 	done := make(chan struct{})
@@ -160,27 +206,54 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// fmt.Printf("[PROXY] %s %s took %v\n", req.Method, req.URL.Path, time.Since(start))
 }
 
-func stripPrefix(path, prefix string) string {
+func schemeOf(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
 
-	if strings.HasSuffix(prefix, "/*") {
-		basePrefix := strings.TrimSuffix(prefix, "/*")
-		stripped := strings.TrimPrefix(path, basePrefix)
-		if stripped == "" || stripped == "/" {
-			return "/"
-		}
-		if !strings.HasPrefix(stripped, "/") {
-			return "/" + stripped
+// forwardedElement returns the RFC 7239 Forwarded element for a request.
+// IPv6 addresses are bracketed and quoted; an unknown client is "unknown".
+func forwardedElement(clientIP, host, proto string) string {
+	var b strings.Builder
+	b.WriteString("for=")
+	switch {
+	case clientIP == "":
+		b.WriteString("unknown")
+	case strings.Contains(clientIP, ":"):
+		b.WriteString(`"[` + clientIP + `]"`)
+	default:
+		b.WriteString(clientIP)
+	}
+	if host != "" {
+		b.WriteString(";host=" + forwardedValue(host))
+	}
+	b.WriteString(";proto=" + proto)
+	return b.String()
+}
+
+// forwardedValue quotes v unless it is a valid RFC 7230 token.
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return strconv.Quote(v)
 		}
-		return stripped
 	}
-	return strings.TrimPrefix(path, prefix)
+	return v
 }
 
-func schemeOf(req *http.Request) string {
-	if req.TLS != nil {
-		return "https"
+func isTokenChar(c rune) bool {
+	return c < 0x7f && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// viaElement returns "1.1 balto" for HTTP/1.1 and "2 balto" for HTTP/2.
+func viaElement(major, minor int, pseudonym string) string {
+	if major >= 2 {
+		return strconv.Itoa(major) + " " + pseudonym
 	}
-	return "http"
+	return fmt.Sprintf("%d.%d %s", major, minor, pseudonym)
 }
 
 func copyHeaders(src, dst http.Header) {
@@ -223,6 +223,367 @@ func TestProxyStripsPrefixCorrectly(t *testing.T) {
 	}
 }
 
+func TestProxyPreservesPath(t *testing.T) {
+	backend := setupTestBackend(t)
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	rt := router.NewRouter().AddWithOptions("keep.com", "/api/*", []*url.URL{u}, router.RouteOptions{Rewrite: &router.Rewrite{Keep: true}})
+
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/api/users/123", nil)
+	req.Host = "keep.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("X-Received-Path") != "/api/users/123" {
+		t.Errorf("expected original path /api/users/123, got %s", resp.Header.Get("X-Received-Path"))
+	}
+}
+
+func TestProxyRewritesPath(t *testing.T) {
+	backend := setupTestBackend(t)
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	rt := router.NewRouter().
+		AddWithOptions("rw.com", "/users/:id", []*url.URL{u}, router.RouteOptions{Rewrite: &router.Rewrite{Template: "/v2/accounts/{id}"}}).
+		AddWithOptions("rw.com", "/old/*", []*url.URL{u}, router.RouteOptions{Rewrite: &router.Rewrite{Prefix: "/new"}})
+
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	for path, want := range map[string]string{
+		"/users/42":    "/v2/accounts/42",
+		"/old/a/b?q=1": "/new/a/b",
+		"/old":         "/new",
+	} {
+		req, _ := http.NewRequest("GET", proxyServer.URL+path, nil)
+		req.Host = "rw.com"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Received-Path"); got != want {
+			t.Errorf("%s: expected backend path %s, got %s", path, want, got)
+		}
+	}
+}
+
+func TestProxyStripsParameterizedWildcardPrefix(t *testing.T) {
+	backend := setupTestBackend(t)
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	rt := router.NewRouter().Add("files.com", "/users/:id{int}/*rest", []*url.URL{u})
+
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	for path, want := range map[string]string{
+		"/users/42/docs/a.txt": "/docs/a.txt",
+		"/users/42":            "/",
+	} {
+		req, _ := http.NewRequest("GET", proxyServer.URL+path, nil)
+		req.Host = "files.com"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Received-Path"); got != want {
+			t.Errorf("%s: expected backend path %s, got %s", path, want, got)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/users/bob/docs", nil)
+	req.Host = "files.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a non-numeric id, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyMatchesRequestConditions(t *testing.T) {
+	v1 := setupTestBackend(t)
+	defer v1.Close()
+	v2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "v2")
+	}))
+	defer v2.Close()
+
+	u1, _ := url.Parse(v1.URL)
+	u2, _ := url.Parse(v2.URL)
+	rt := router.NewRouter().
+		Add("split.com", "/api", []*url.URL{u1}).
+		AddWithOptions("split.com", "/api", []*url.URL{u2}, router.RouteOptions{Match: &router.Match{
+			Headers: []router.ValueMatch{{Name: "X-Api-Version", Value: "2"}},
+		}})
+
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	for version, want := range map[string]string{"": "ok", "1": "ok", "2": "v2"} {
+		req, _ := http.NewRequest("GET", proxyServer.URL+"/api", nil)
+		req.Host = "split.com"
+		if version != "" {
+			req.Header.Set("X-Api-Version", version)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Backend"); got != want {
+			t.Errorf("version %q: expected backend %s, got %q", version, want, got)
+		}
+	}
+}
+
+func TestProxyServesDirectRoutes(t *testing.T) {
+	rt, err := router.BuildFromConfig([]router.InitialRoutes{
+		{Domain: "site.com", PathPrefix: "/old/*", Redirect: &router.Redirect{URL: "https://new.site.com", PreservePath: true, PreserveQuery: true}},
+		{Domain: "site.com", PathPrefix: "/robots.txt", Response: &router.DirectResponse{Body: "User-agent: *\n", Headers: map[string]string{"Content-Type": "text/plain", "Cache-Control": "max-age=60"}},
+			Headers: &router.Headers{Response: &router.HeaderRules{Set: map[string]string{"Cache-Control": "no-store"}}}},
+	})
+	if err != nil {
+		t.Fatalf("failed to build router: %v", err)
+	}
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/old/docs/a?page=2", nil)
+	req.Host = "site.com"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "https://new.site.com/docs/a?page=2" {
+		t.Errorf("unexpected redirect %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	req, _ = http.NewRequest("GET", proxyServer.URL+"/robots.txt", nil)
+	req.Host = "site.com"
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "User-agent: *\n" || resp.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected response %d %q %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("response rules should override the response's headers, got Cache-Control %q", got)
+	}
+}
+
+func TestProxyAppliesHeaderRules(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25")
+		w.Header().Set("X-User", r.Header.Get("X-User"))
+		w.Header().Set("X-Had-Cookie", fmt.Sprint(r.Header.Get("Cookie") != ""))
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	rt := router.NewRouter().AddWithOptions("hdr.com", "/users/:id", []*url.URL{u}, router.RouteOptions{Headers: &router.Headers{
+		Request: &router.HeaderRules{
+			Set:    map[string]string{"X-User": "{param.id}@{client_ip}"},
+			Remove: []string{"Cookie"},
+		},
+		Response: &router.HeaderRules{
+			Set:    map[string]string{"X-Backend-Id": "{backend.id}"},
+			Add:    map[string]string{"X-Frame-Options": "DENY"},
+			Remove: []string{"Server"},
+		},
+	}})
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/users/7", nil)
+	req.Host = "hdr.com"
+	req.Header.Set("Cookie", "session=1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("X-User"); got != "7@127.0.0.1" {
+		t.Errorf("request header not templated: %q", got)
+	}
+	if resp.Header.Get("X-Had-Cookie") != "false" {
+		t.Error("request header not removed")
+	}
+	if resp.Header.Get("Server") != "" || resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("response rules not applied: %v", resp.Header)
+	}
+	if got := resp.Header.Get("X-Backend-Id"); got != router.BackendID("hdr.com", u) {
+		t.Errorf("unexpected X-Backend-Id %q", got)
+	}
+}
+
+func TestProxyHostHeaderPolicy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Received-Host", r.Host)
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	rt := router.NewRouter()
+	for path, policy := range map[string]router.HostHeader{
+		"/default":  "",
+		"/preserve": router.HostHeaderPreserve,
+		"/fixed":    "api.internal",
+	} {
+		rt = rt.AddWithOptions("vhost.com", path, []*url.URL{u}, router.RouteOptions{HostHeader: policy})
+	}
+	proxyServer := setupProxy(rt)
+	defer proxyServer.Close()
+
+	for path, want := range map[string]string{
+		"/default":  u.Host,
+		"/preserve": "vhost.com",
+		"/fixed":    "api.internal",
+	} {
+		req, _ := http.NewRequest("GET", proxyServer.URL+path, nil)
+		req.Host = "vhost.com"
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Received-Host"); got != want {
+			t.Errorf("%s: backend got Host %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestProxyForwardedAndVia(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Received-Forwarded", r.Header.Get("Forwarded"))
+		w.Header().Set("X-Received-Via", r.Header.Get("Via"))
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	trusted, _ := proxy.ParseTrustedProxies([]string{"127.0.0.1"})
+	p := proxy.NewWithOptions(router.NewRouter().Add("fwd.com:8080", "/", []*url.URL{u}), proxy.Options{
+		Forwarded: true, Via: "balto", TrustedProxies: trusted,
+	})
+	proxyServer := httptest.NewServer(p)
+	defer proxyServer.Close()
+
+	req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+	req.Host = "fwd.com:8080"
+	req.Header.Set("Forwarded", "for=198.51.100.7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got, want := resp.Header.Get("X-Received-Forwarded"), `for=198.51.100.7, for=127.0.0.1;host="fwd.com:8080";proto=http`; got != want {
+		t.Errorf("Forwarded: got %q, want %q", got, want)
+	}
+	if got := resp.Header.Get("X-Received-Via"); got != "1.1 balto" {
+		t.Errorf("request Via: got %q", got)
+	}
+	if got := resp.Header.Get("Via"); got != "1.1 balto" {
+		t.Errorf("response Via: got %q", got)
+	}
+}
+
+func TestProxyTrustedProxies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Received-XFF", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Received-Proto", r.Header.Get("X-Forwarded-Proto"))
+		w.Header().Set("X-Received-Client", r.Header.Get("X-Client-IP"))
+	}))
+	defer backend.Close()
+
+	u, _ := url.Parse(backend.URL)
+	rt := router.NewRouter().AddWithOptions("ip.com", "/", []*url.URL{u}, router.RouteOptions{Headers: &router.Headers{
+		Request: &router.HeaderRules{Set: map[string]string{"X-Client-IP": "{client_ip}"}},
+	}})
+
+	cases := []struct {
+		name    string
+		trusted []string
+		headers map[string]string
+		xff     string
+		client  string
+	}{
+		{"untrusted peer is the client", nil,
+			map[string]string{"X-Forwarded-For": "6.6.6.6", "X-Forwarded-Proto": "https"},
+			"127.0.0.1", "127.0.0.1"},
+		{"trusted peer reports the client", []string{"127.0.0.0/8"},
+			map[string]string{"X-Forwarded-For": "203.0.113.9"},
+			"203.0.113.9, 127.0.0.1", "203.0.113.9"},
+		{"spoofed entries left of the first untrusted hop are ignored", []string{"127.0.0.0/8", "10.0.0.0/8"},
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.9, 10.0.0.2"},
+			"6.6.6.6, 203.0.113.9, 10.0.0.2, 127.0.0.1", "203.0.113.9"},
+		{"ports are stripped from X-Forwarded-For hops", []string{"127.0.0.0/8", "10.0.0.0/8"},
+			map[string]string{"X-Forwarded-For": "203.0.113.9:5678, [2001:db8::9]:443, 10.0.0.2:80"},
+			"203.0.113.9:5678, [2001:db8::9]:443, 10.0.0.2:80, 127.0.0.1", "2001:db8::9"},
+		{"Forwarded is used without X-Forwarded-For", []string{"127.0.0.1"},
+			map[string]string{"Forwarded": `for="[2001:db8::7]:4711";proto=https`},
+			"127.0.0.1", "2001:db8::7"},
+		{"unknown hop stops at the last trusted one", []string{"127.0.0.0/8", "10.0.0.0/8"},
+			map[string]string{"X-Forwarded-For": "203.0.113.9, unknown, 10.0.0.2"},
+			"203.0.113.9, unknown, 10.0.0.2, 127.0.0.1", "10.0.0.2"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			trusted, err := proxy.ParseTrustedProxies(c.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proxyServer := httptest.NewServer(proxy.NewWithOptions(rt, proxy.Options{TrustedProxies: trusted}))
+			defer proxyServer.Close()
+
+			req, _ := http.NewRequest("GET", proxyServer.URL+"/", nil)
+			req.Host = "ip.com"
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if got := resp.Header.Get("X-Received-XFF"); got != c.xff {
+				t.Errorf("X-Forwarded-For: got %q, want %q", got, c.xff)
+			}
+			if got := resp.Header.Get("X-Received-Client"); got != c.client {
+				t.Errorf("client IP: got %q, want %q", got, c.client)
+			}
+			if c.trusted == nil && resp.Header.Get("X-Received-Proto") != "http" {
+				t.Errorf("untrusted X-Forwarded-Proto kept: %q", resp.Header.Get("X-Received-Proto"))
+			}
+		})
+	}
+
+	if _, err := proxy.ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
+
 func TestProxyPassesPathParams(t *testing.T) {
 	backend := setupTestBackend(t)
 	defer backend.Close()
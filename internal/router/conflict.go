@@ -0,0 +1,118 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrDuplicateRoute means a route with the same host, path and
+	// conditions is already registered.
+	ErrDuplicateRoute = errors.New("duplicate route")
+	// ErrAmbiguousRoute means a registered route matches exactly the same
+	// requests under different parameter names, e.g. "/users/:id" and
+	// "/users/:name".
+	ErrAmbiguousRoute = errors.New("ambiguous route")
+)
+
+// PatternKey identifies the requests a route matches: like MatchKey, but
+// with parameter and catch-all names left out. Two routes with the same
+// PatternKey are ambiguous.
+func PatternKey(host Host, path string, m *Match) string {
+	segments := pathToSegments(normalizePrefix(path))
+	var b strings.Builder
+	b.WriteString(string(host.normalize()))
+	if len(segments) == 0 {
+		b.WriteByte('/')
+	}
+	for _, seg := range segments {
+		b.WriteByte('/')
+		switch {
+		case seg[0] == '*':
+			b.WriteByte('*')
+		case seg[0] == ':':
+			b.WriteByte(':')
+			if i := strings.IndexByte(seg, '{'); i != -1 {
+				b.WriteString(seg[i:])
+			}
+		default:
+			b.WriteString(seg)
+		}
+	}
+	if c, err := m.compile(); err == nil {
+		m = c
+	}
+	if s := m.String(); s != "" {
+		b.WriteString(" [" + s + "]")
+	}
+	return b.String()
+}
+
+// Conflict reports whether a route for host, path and m would clash with a
+// registered one: ErrDuplicateRoute for the same key, ErrAmbiguousRoute for
+// the same pattern under other parameter names. Constrained parameters
+// whose expressions differ are never reported, even if they overlap; lookup
+// tries them in segment order.
+func (r *Router) Conflict(host Host, path string, m *Match) error {
+	h := host.normalize()
+	root := r.root(h)
+	if root == nil {
+		return nil
+	}
+	key := MatchKey(h, path, m)
+	pattern := PatternKey(h, path, m)
+
+	var err error
+	root.walk(func(route *Route) {
+		if err != nil {
+			return
+		}
+		switch existing := route.Key(h); {
+		case existing == key:
+			err = fmt.Errorf("%w: %s", ErrDuplicateRoute, key)
+		case PatternKey(h, route.Prefix, route.Match) == pattern:
+			err = fmt.Errorf("%w: %s matches the same requests as %s", ErrAmbiguousRoute, key, existing)
+		}
+	})
+	return err
+}
+
+// Insert is AddWithOptions for callers that must not replace routes: it
+// rejects invalid hosts, paths, conditions, rewrites, header rules, host
+// headers and responses, routes without backends, duplicates and ambiguous
+// routes instead of returning the router unchanged or overwriting.
+func (r *Router) Insert(host Host, path string, services []*url.URL, opts RouteOptions) (*Router, error) {
+	if err := ValidateHost(host); err != nil {
+		return r, err
+	}
+	if err := ValidatePath(path); err != nil {
+		return r, err
+	}
+	if err := ValidateMatch(opts.Match); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := ValidateRewrite(path, opts.Rewrite); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := ValidateHeaders(path, opts.Headers); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := ValidateHostHeader(opts.HostHeader); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := ValidateRedirect(opts.Redirect); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := ValidateResponse(opts.Response); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := validateDirect(services, opts); err != nil {
+		return r, fmt.Errorf("route %s: %w", RouteKey(host, path), err)
+	}
+	if err := r.Conflict(host, path, opts.Match); err != nil {
+		return r, err
+	}
+	return r.AddWithOptions(host, path, services, opts), nil
+}
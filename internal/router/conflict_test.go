@@ -0,0 +1,86 @@
+package router
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestRouterInsertConflicts(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r, err := NewRouter().Insert(Host("api.com"), "/users/:id", backend, RouteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = r.Insert(Host("api.com"), "/files/*rest", backend, RouteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		host string
+		path string
+		m    *Match
+		want error
+	}{
+		{"API.com", "/users/:id/", nil, ErrDuplicateRoute},
+		{"api.com", "/users/:name", nil, ErrAmbiguousRoute},
+		{"api.com", "/files/*", nil, ErrAmbiguousRoute},
+		{"api.com", "/files/*path", nil, ErrAmbiguousRoute},
+		{"api.com", "/users/:id{int}", nil, nil},
+		{"api.com", "/users/:name", &Match{Methods: []string{"GET"}}, nil},
+		{"other.com", "/users/:name", nil, nil},
+	}
+	for _, c := range cases {
+		_, err := r.Insert(Host(c.host), c.path, backend, RouteOptions{Match: c.m})
+		if !errors.Is(err, c.want) || (c.want == nil) != (err == nil) {
+			t.Errorf("%s%s: got %v, want %v", c.host, c.path, err, c.want)
+		}
+	}
+
+	if _, err := r.Insert(Host("api.com"), "/x", nil, RouteOptions{}); err == nil {
+		t.Error("expected an error without backends")
+	}
+}
+
+func TestBuildFromConfigRejectsConflicts(t *testing.T) {
+	_, err := BuildFromConfig([]InitialRoutes{
+		{Domain: "a.com", PathPrefix: "/users/:id", Ports: []string{"8001"}},
+		{Domain: "a.com", PathPrefix: "/users/:uid", Ports: []string{"8002"}},
+	})
+	if !errors.Is(err, ErrAmbiguousRoute) {
+		t.Errorf("expected an ambiguous route error, got %v", err)
+	}
+}
+
+func TestRouterParamOrderIsDeterministic(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	// Added in both orders; /b/:z would be ambiguous under Insert but Add
+	// allows it, and lookup must still pick the same route every time.
+	for _, order := range [][]string{{"/b/:z", "/b/:a"}, {"/b/:a", "/b/:z"}} {
+		r := NewRouter()
+		for _, p := range order {
+			r = r.Add(Host("api.com"), p, backend)
+		}
+		for i := 0; i < 50; i++ {
+			route, params, ok := r.Lookup(Host("api.com"), "/b/1")
+			if !ok || route.Prefix != "/b/:a" || params.Get("a") != "1" {
+				t.Fatalf("order %v: got %s %v", order, route.Prefix, params)
+			}
+		}
+	}
+}
+
+func TestPatternKey(t *testing.T) {
+	cases := map[string]string{
+		"/":                "a.com/",
+		"/users/:id":       "a.com/users/:",
+		"/users/:id{int}/": "a.com/users/:{int}",
+		"/files/*rest":     "a.com/files/*",
+	}
+	for path, want := range cases {
+		if got := PatternKey(Host("A.com"), path, nil); got != want {
+			t.Errorf("%s: got %s, want %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/diabeney/balto/internal/core"
+)
+
+// Headers edits the headers of a route's requests before they are
+// forwarded and of its responses before they are returned. Request rules
+// run after the proxy's own X-Forwarded-* and X-Param-* headers are set,
+// and response rules after hop-by-hop headers are removed or, on direct
+// responses, after the response's own headers are set, so both can
+// override them:
+//
+//	request:
+//	  set: {X-Client-IP: "{client_ip}", X-User-Id: "{param.id}"}
+//	  remove: [Cookie]
+//	response:
+//	  add: {Strict-Transport-Security: "max-age=31536000"}
+//	  set: {X-Backend-Id: "{backend.id}"}
+//	  remove: [Server]
+//
+// Values are templates; see HeaderContext for the variables.
+type Headers struct {
+	Request  *HeaderRules `json:"request,omitempty" yaml:"request"`
+	Response *HeaderRules `json:"response,omitempty" yaml:"response"`
+}
+
+// HeaderRules edits a header set: Remove first, then Set, then Add.
+type HeaderRules struct {
+	// Set replaces any existing values of a header.
+	Set map[string]string `json:"set,omitempty" yaml:"set"`
+	// Add appends a value, keeping existing ones.
+	Add map[string]string `json:"add,omitempty" yaml:"add"`
+	// Remove deletes headers.
+	Remove []string `json:"remove,omitempty" yaml:"remove"`
+
+	set, add []headerValue // sorted by name
+	remove   []string
+}
+
+type headerValue struct {
+	name  string
+	parts []templatePart
+}
+
+// templatePart is literal text, or a variable when variable is set.
+type templatePart struct {
+	text     string
+	variable bool
+}
+
+// HeaderContext holds the values header templates can refer to:
+//
+//	{client_ip}      the client's IP address
+//	{host}           the request's Host
+//	{method}         the request method
+//	{path}           the request path, before any rewrite
+//	{param.<name>}   a parameter or named wildcard of the route
+//	{backend.id}     the selected backend's ID
+//	{backend.url}    the selected backend's URL
+//	{backend.host}   the selected backend's host:port
+//
+// Backend variables are empty on routes without a pool.
+type HeaderContext struct {
+	Request  *http.Request
+	Params   Params
+	ClientIP string
+	Backend  *core.Backend
+}
+
+var headerVariables = map[string]bool{
+	"client_ip": true, "host": true, "method": true, "path": true,
+	"backend.id": true, "backend.url": true, "backend.host": true,
+}
+
+func (c HeaderContext) value(variable string) string {
+	if name, ok := strings.CutPrefix(variable, "param."); ok {
+		return c.Params.Get(name)
+	}
+	switch variable {
+	case "client_ip":
+		return c.ClientIP
+	case "host":
+		return c.Request.Host
+	case "method":
+		return c.Request.Method
+	case "path":
+		return c.Request.URL.Path
+	}
+	if c.Backend == nil || c.Backend.URL == nil {
+		return ""
+	}
+	switch variable {
+	case "backend.id":
+		return c.Backend.ID
+	case "backend.url":
+		return c.Backend.URL.String()
+	case "backend.host":
+		return c.Backend.URL.Host
+	}
+	return ""
+}
+
+// compile validates h against the route path and returns a copy ready for
+// use. A nil Headers compiles to nil.
+func (h *Headers) compile(path string) (*Headers, error) {
+	if h == nil {
+		return nil, nil
+	}
+	names := paramNames(path)
+	req, err := h.Request.compile(names)
+	if err != nil {
+		return nil, fmt.Errorf("headers: request: %w", err)
+	}
+	resp, err := h.Response.compile(names)
+	if err != nil {
+		return nil, fmt.Errorf("headers: response: %w", err)
+	}
+	if req == nil && resp == nil {
+		return nil, nil
+	}
+	return &Headers{Request: req, Response: resp}, nil
+}
+
+func (hr *HeaderRules) compile(params map[string]bool) (*HeaderRules, error) {
+	if hr == nil || (len(hr.Set) == 0 && len(hr.Add) == 0 && len(hr.Remove) == 0) {
+		return nil, nil
+	}
+	out := *hr
+	var err error
+	if out.set, err = compileHeaderValues(hr.Set, params); err != nil {
+		return nil, err
+	}
+	if out.add, err = compileHeaderValues(hr.Add, params); err != nil {
+		return nil, err
+	}
+	out.remove = make([]string, len(hr.Remove))
+	for i, name := range hr.Remove {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		out.remove[i] = http.CanonicalHeaderKey(name)
+	}
+	return &out, nil
+}
+
+func compileHeaderValues(values map[string]string, params map[string]bool) ([]headerValue, error) {
+	out := make([]headerValue, 0, len(values))
+	for name, value := range values {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		parts, err := parseHeaderTemplate(value, params)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		out = append(out, headerValue{name: http.CanonicalHeaderKey(name), parts: parts})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+func parseHeaderTemplate(tmpl string, params map[string]bool) ([]templatePart, error) {
+	if strings.ContainsAny(tmpl, "\r\n") {
+		return nil, errors.New("line breaks are not allowed in header values")
+	}
+	if !httpguts.ValidHeaderFieldValue(tmpl) {
+		return nil, fmt.Errorf("invalid header value %q", tmpl)
+	}
+	var parts []templatePart
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start == -1 {
+			if tmpl != "" {
+				parts = append(parts, templatePart{text: tmpl})
+			}
+			return parts, nil
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated variable in %q", tmpl)
+		}
+		variable := tmpl[start+1 : start+end]
+		name, isParam := strings.CutPrefix(variable, "param.")
+		if (isParam && !params[name]) || (!isParam && !headerVariables[variable]) {
+			return nil, fmt.Errorf("unknown variable {%s}", variable)
+		}
+		if start > 0 {
+			parts = append(parts, templatePart{text: tmpl[:start]})
+		}
+		parts = append(parts, templatePart{text: variable, variable: true})
+		tmpl = tmpl[start+end+1:]
+	}
+}
+
+// ValidateHeaders reports whether h can be used on a route with path.
+func ValidateHeaders(path string, h *Headers) error {
+	_, err := h.compile(path)
+	return err
+}
+
+// Apply edits header according to the rules, expanding templates with c.
+// Calling it on nil rules does nothing.
+func (hr *HeaderRules) Apply(header http.Header, c HeaderContext) {
+	if hr == nil {
+		return
+	}
+	for _, name := range hr.remove {
+		header.Del(name)
+	}
+	for _, v := range hr.set {
+		header[v.name] = []string{v.expand(c)}
+	}
+	for _, v := range hr.add {
+		header[v.name] = append(header[v.name], v.expand(c))
+	}
+}
+
+func (v headerValue) expand(c HeaderContext) string {
+	if len(v.parts) == 1 && !v.parts[0].variable {
+		return v.parts[0].text
+	}
+	var b strings.Builder
+	for _, p := range v.parts {
+		if !p.variable {
+			b.WriteString(p.text)
+			continue
+		}
+		// Variables come from the request; keep them from ending the header
+		// or making it invalid.
+		b.WriteString(strings.Map(func(r rune) rune {
+			if r == '\t' || (r >= ' ' && r != 0x7f) {
+				return r
+			}
+			return -1
+		}, c.value(p.text)))
+	}
+	return b.String()
+}
+
+// String returns the canonical form of h, "" for nil.
+func (h *Headers) String() string {
+	if h == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range []struct {
+		name  string
+		rules *HeaderRules
+	}{{"request", h.Request}, {"response", h.Response}} {
+		if r.rules == nil {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s:{set:%s add:%s remove:%v}", r.name, sortedMap(r.rules.Set), sortedMap(r.rules.Add), r.rules.Remove)
+	}
+	return b.String()
+}
+
+func sortedMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", k, m[k])
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func validHeaderName(name string) bool {
+	return httpguts.ValidHeaderFieldName(name)
+}
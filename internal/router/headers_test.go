@@ -0,0 +1,94 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/diabeney/balto/internal/core"
+)
+
+func TestHeaderRulesApply(t *testing.T) {
+	headers := &Headers{Request: &HeaderRules{
+		Set: map[string]string{
+			"x-user":    "user-{param.id}",
+			"X-Client":  "{client_ip}",
+			"X-Backend": "{backend.id} {backend.host}",
+			"X-Origin":  "{method} {host}{path}",
+		},
+		Add:    map[string]string{"Via": "balto"},
+		Remove: []string{"cookie", "x-user"},
+	}}
+	r := NewRouter().AddWithOptions(Host("a.com"), "/users/:id", []*url.URL{mustParseURL("http://localhost:9000")}, RouteOptions{Headers: headers})
+	route, params, ok := r.Lookup(Host("a.com"), "/users/7")
+	if !ok || route.Headers == nil {
+		t.Fatal("expected the route with its header rules")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://a.com/users/7", nil)
+	backend := &core.Backend{ID: "b1", URL: mustParseURL("http://10.0.0.1:8080")}
+	h := http.Header{"Cookie": {"s=1"}, "X-User": {"spoofed"}, "Via": {"1.1 edge"}}
+	route.Headers.Request.Apply(h, HeaderContext{Request: req, Params: params, ClientIP: "192.0.2.1", Backend: backend})
+
+	want := http.Header{
+		"X-User":    {"user-7"},
+		"X-Client":  {"192.0.2.1"},
+		"X-Backend": {"b1 10.0.0.1:8080"},
+		"X-Origin":  {"GET a.com/users/7"},
+		"Via":       {"1.1 edge", "balto"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("got %v, want %v", h, want)
+	}
+
+	// Without a backend, backend variables expand to nothing.
+	h = http.Header{}
+	route.Headers.Request.Apply(h, HeaderContext{Request: req, Params: params})
+	if got := h.Get("X-Backend"); got != " " {
+		t.Errorf("expected empty backend variables, got %q", got)
+	}
+}
+
+func TestHeaderTemplateStripsLineBreaks(t *testing.T) {
+	headers := &Headers{Request: &HeaderRules{Set: map[string]string{"X-Name": "{param.name}"}}}
+	r := NewRouter().AddWithOptions(Host("a.com"), "/n/:name", []*url.URL{mustParseURL("http://localhost:9000")}, RouteOptions{Headers: headers})
+	route, params, _ := r.Lookup(Host("a.com"), "/n/a\r\nX-Evil: 1")
+	h := http.Header{}
+	route.Headers.Request.Apply(h, HeaderContext{Request: httptest.NewRequest(http.MethodGet, "/", nil), Params: params})
+	if got := h.Get("X-Name"); got != "aX-Evil: 1" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	for _, h := range []*Headers{
+		{Request: &HeaderRules{Set: map[string]string{"X-A": "{param.name}"}}},
+		{Request: &HeaderRules{Set: map[string]string{"X-A": "{bogus}"}}},
+		{Request: &HeaderRules{Set: map[string]string{"X-A": "{client_ip"}}},
+		{Request: &HeaderRules{Set: map[string]string{"X-A": "a\r\nb"}}},
+		{Response: &HeaderRules{Add: map[string]string{"Bad Name": "x"}}},
+		{Response: &HeaderRules{Remove: []string{""}}},
+		{Response: &HeaderRules{Set: map[string]string{"X-(A)": "x"}}},
+		{Response: &HeaderRules{Remove: []string{"X-\xffA"}}},
+		{Response: &HeaderRules{Set: map[string]string{"X-A": "a\x00b"}}},
+	} {
+		if err := ValidateHeaders("/users/:id", h); err == nil {
+			t.Errorf("%s: expected an error", h)
+		}
+		r := NewRouter().AddWithOptions(Host("a.com"), "/users/:id", []*url.URL{mustParseURL("http://localhost:9000")}, RouteOptions{Headers: h})
+		if len(r.Routes()) != 0 {
+			t.Errorf("%s: invalid header rules were added", h)
+		}
+	}
+	if err := ValidateHeaders("/files/*rest", &Headers{Request: &HeaderRules{Set: map[string]string{"X-File": "{param.rest}"}}}); err != nil {
+		t.Errorf("valid rules rejected: %v", err)
+	}
+	if _, err := NewRouter().Insert(Host("a.com"), "/robots.txt", nil, RouteOptions{
+		Response: &DirectResponse{Body: "x"},
+		Headers:  &Headers{Request: &HeaderRules{Remove: []string{"Cookie"}}},
+	}); err == nil {
+		t.Error("request rules on a direct response should be rejected")
+	}
+}
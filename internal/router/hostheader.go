@@ -0,0 +1,47 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// HostHeader selects the Host header of requests forwarded to a route's
+// backends: HostHeaderBackend, HostHeaderPreserve or any other value, which
+// is sent as is, e.g. "api.internal".
+type HostHeader string
+
+const (
+	// HostHeaderBackend sends the selected backend's host:port. It is the
+	// default.
+	HostHeaderBackend HostHeader = "backend"
+	// HostHeaderPreserve sends the Host the client asked for, for backends
+	// that serve several virtual hosts.
+	HostHeaderPreserve HostHeader = "preserve"
+)
+
+// ValidateHostHeader reports whether h is a usable Host header policy: a
+// fixed value must be a host, optionally with a port.
+func ValidateHostHeader(h HostHeader) error {
+	switch h {
+	case "", HostHeaderBackend, HostHeaderPreserve:
+		return nil
+	}
+	u, err := url.Parse("http://" + string(h))
+	if err != nil || u.Host != string(h) || strings.ContainsAny(string(h), " \t@") {
+		return fmt.Errorf("invalid host header %q", h)
+	}
+	return nil
+}
+
+// UpstreamHost returns the Host header for a request from a client that
+// asked for clientHost, forwarded to the backend at backend.
+func (r Route) UpstreamHost(clientHost string, backend *url.URL) string {
+	switch r.HostHeader {
+	case "", HostHeaderBackend:
+		return backend.Host
+	case HostHeaderPreserve:
+		return clientHost
+	}
+	return string(r.HostHeader)
+}
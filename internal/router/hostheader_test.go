@@ -0,0 +1,31 @@
+package router
+
+import "testing"
+
+func TestUpstreamHost(t *testing.T) {
+	backend := mustParseURL("http://10.0.0.1:8080")
+	for policy, want := range map[HostHeader]string{
+		"":                  "10.0.0.1:8080",
+		HostHeaderBackend:   "10.0.0.1:8080",
+		HostHeaderPreserve:  "shop.example.com",
+		"api.internal":      "api.internal",
+		"api.internal:8443": "api.internal:8443",
+	} {
+		if got := (Route{HostHeader: policy}).UpstreamHost("shop.example.com", backend); got != want {
+			t.Errorf("%q: got %s, want %s", policy, got, want)
+		}
+	}
+}
+
+func TestValidateHostHeader(t *testing.T) {
+	for _, h := range []HostHeader{"", "backend", "preserve", "api.internal", "api.internal:8443", "[::1]:80"} {
+		if err := ValidateHostHeader(h); err != nil {
+			t.Errorf("%q: unexpected error %v", h, err)
+		}
+	}
+	for _, h := range []HostHeader{"a.com/x", "a b", "user@a.com", "a.com?x"} {
+		if err := ValidateHostHeader(h); err == nil {
+			t.Errorf("%q: expected an error", h)
+		}
+	}
+}
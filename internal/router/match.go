@@ -0,0 +1,194 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Match restricts a route to requests meeting every condition. Routes on
+// the same host and path are tried most specific first, so a request that
+// satisfies several is sent to the one with the most conditions; ties are
+// broken by the route key.
+type Match struct {
+	Methods []string     `json:"methods,omitempty" yaml:"methods"` // any of these; all when empty
+	Headers []ValueMatch `json:"headers,omitempty" yaml:"headers"`
+	Query   []ValueMatch `json:"query,omitempty" yaml:"query"`
+}
+
+// ValueMatch tests a header or query parameter. With neither Value nor
+// Regex set it only requires the name to be present. Any of several values
+// may match; Regex must match the whole value.
+type ValueMatch struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value,omitempty" yaml:"value"`
+	Regex string `json:"regex,omitempty" yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+// compile validates m and returns a normalized copy ready for matching.
+// A nil or empty Match compiles to nil.
+func (m *Match) compile() (*Match, error) {
+	if m == nil || len(m.Methods)+len(m.Headers)+len(m.Query) == 0 {
+		return nil, nil
+	}
+
+	out := &Match{}
+	for _, method := range m.Methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			return nil, fmt.Errorf("empty method")
+		}
+		out.Methods = append(out.Methods, method)
+	}
+	sort.Strings(out.Methods)
+
+	var err error
+	if out.Headers, err = compileValues("header", m.Headers, http.CanonicalHeaderKey); err != nil {
+		return nil, err
+	}
+	if out.Query, err = compileValues("query", m.Query, func(s string) string { return s }); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func compileValues(kind string, in []ValueMatch, canonical func(string) string) ([]ValueMatch, error) {
+	out := make([]ValueMatch, 0, len(in))
+	for _, v := range in {
+		if v.Name == "" {
+			return nil, fmt.Errorf("%s match without a name", kind)
+		}
+		if v.Value != "" && v.Regex != "" {
+			return nil, fmt.Errorf("%s %s: value and regex are mutually exclusive", kind, v.Name)
+		}
+		v.Name = canonical(v.Name)
+		if v.Regex != "" {
+			re, err := regexp.Compile("^(?:" + v.Regex + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", kind, v.Name, err)
+			}
+			v.re = re
+		}
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out, nil
+}
+
+// ValidateMatch reports whether m can be used in RouteOptions.
+func ValidateMatch(m *Match) error {
+	_, err := m.compile()
+	return err
+}
+
+// specificity is the number of conditions; more specific routes are tried
+// first.
+func (m *Match) specificity() int {
+	if m == nil {
+		return 0
+	}
+	n := len(m.Headers) + len(m.Query)
+	if len(m.Methods) > 0 {
+		n++
+	}
+	return n
+}
+
+// matches reports whether req meets every condition. A nil Match matches
+// everything; a nil req only matches a nil Match.
+func (m *Match) matches(req *http.Request) bool {
+	if m == nil {
+		return true
+	}
+	if req == nil {
+		return false
+	}
+	if len(m.Methods) > 0 {
+		ok := false
+		for _, method := range m.Methods {
+			if req.Method == method {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, v := range m.Headers {
+		if !v.matches(req.Header[v.Name]) {
+			return false
+		}
+	}
+	if len(m.Query) > 0 {
+		query := req.URL.Query()
+		for _, v := range m.Query {
+			if !v.matches(query[v.Name]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v ValueMatch) matches(values []string) bool {
+	if len(values) == 0 {
+		return false
+	}
+	if v.Value == "" && v.re == nil {
+		return true
+	}
+	for _, s := range values {
+		if v.re != nil && v.re.MatchString(s) || v.re == nil && s == v.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func (v ValueMatch) String() string {
+	switch {
+	case v.Regex != "":
+		return v.Name + "~" + v.Regex
+	case v.Value != "":
+		return v.Name + "=" + v.Value
+	}
+	return v.Name
+}
+
+// String returns the canonical form used in route keys, e.g.
+// "method=GET,POST header:X-Api-Version=2 query:beta".
+func (m *Match) String() string {
+	if m == nil {
+		return ""
+	}
+	var parts []string
+	if len(m.Methods) > 0 {
+		parts = append(parts, "method="+strings.Join(m.Methods, ","))
+	}
+	for _, v := range m.Headers {
+		parts = append(parts, "header:"+v.String())
+	}
+	for _, v := range m.Query {
+		parts = append(parts, "query:"+v.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// MatchKey returns the key a route with conditions m is registered under:
+// RouteKey followed by the conditions in canonical form, e.g.
+// "example.com/api [method=GET]".
+func MatchKey(host Host, path string, m *Match) string {
+	if c, err := m.compile(); err == nil {
+		m = c
+	}
+	key := RouteKey(host, path)
+	if s := m.String(); s != "" {
+		key += " [" + s + "]"
+	}
+	return key
+}
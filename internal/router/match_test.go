@@ -0,0 +1,102 @@
+package router
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r := NewRouter()
+	r = r.Add(Host("api.com"), "/items", backend)
+	r = r.AddWithOptions(Host("api.com"), "/items", backend, RouteOptions{Match: &Match{Methods: []string{"post", "PUT"}}})
+	r = r.AddWithOptions(Host("api.com"), "/items", backend, RouteOptions{Match: &Match{
+		Headers: []ValueMatch{{Name: "x-api-version", Value: "2"}},
+	}})
+	r = r.AddWithOptions(Host("api.com"), "/items", backend, RouteOptions{Match: &Match{
+		Methods: []string{"POST"},
+		Headers: []ValueMatch{{Name: "X-Api-Version", Regex: "2|3"}},
+	}})
+	r = r.AddWithOptions(Host("api.com"), "/items", backend, RouteOptions{Match: &Match{
+		Query: []ValueMatch{{Name: "beta"}},
+	}})
+	r = r.AddWithOptions(Host("api.com"), "/search/*", backend, RouteOptions{Match: &Match{Methods: []string{"GET"}}})
+	r = r.Add(Host("api.com"), "/*", backend)
+
+	cases := []struct {
+		method  string
+		target  string
+		headers map[string]string
+		want    string
+	}{
+		{"GET", "/items", nil, "api.com/items"},
+		{"PUT", "/items", nil, "api.com/items [method=POST,PUT]"},
+		{"GET", "/items", map[string]string{"X-Api-Version": "2"}, "api.com/items [header:X-Api-Version=2]"},
+		{"GET", "/items", map[string]string{"X-Api-Version": "3"}, "api.com/items"},
+		// Two conditions beat one, whatever order the routes were added in.
+		{"POST", "/items", map[string]string{"X-Api-Version": "2"}, "api.com/items [method=POST header:X-Api-Version~2|3]"},
+		{"POST", "/items", map[string]string{"X-Api-Version": "23"}, "api.com/items [method=POST,PUT]"},
+		{"GET", "/items?beta", nil, "api.com/items [query:beta]"},
+		{"GET", "/search/x", nil, "api.com/search/* [method=GET]"},
+		// No route on /search/* accepts DELETE, so the request falls through.
+		{"DELETE", "/search/x", nil, "api.com/*"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "http://api.com"+c.target, nil)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		route, _, ok := r.Match(req)
+		if !ok {
+			t.Errorf("%s %s: no match", c.method, c.target)
+			continue
+		}
+		if got := route.Pool.Config().ServiceName; got != c.want {
+			t.Errorf("%s %s %v: got %s, want %s", c.method, c.target, c.headers, got, c.want)
+		}
+	}
+
+	if route, _, ok := r.Lookup(Host("api.com"), "/search/x"); !ok || route.Match != nil {
+		t.Errorf("Lookup should ignore conditional routes, got %+v", route)
+	}
+	if got := len(r.Routes()); got != 7 {
+		t.Errorf("expected 7 routes, got %d", got)
+	}
+}
+
+func TestRouterMatchReplace(t *testing.T) {
+	r := NewRouter()
+	m := &Match{Methods: []string{"GET", "HEAD"}}
+	r = r.AddWithOptions(Host("api.com"), "/", []*url.URL{mustParseURL("http://localhost:9001")}, RouteOptions{Match: m})
+	r = r.AddWithOptions(Host("api.com"), "/", []*url.URL{mustParseURL("http://localhost:9002")}, RouteOptions{Match: &Match{Methods: []string{"head", "get"}}})
+
+	routes := r.Routes()
+	if len(routes) != 1 || routes[0].Key != "api.com/ [method=GET,HEAD]" {
+		t.Fatalf("expected one replaced route, got %v", routes)
+	}
+	backend, err := routes[0].Route.NextBackend()
+	if err != nil || backend.URL.Port() != "9002" {
+		t.Errorf("expected the second route to win, got %v, %v", backend, err)
+	}
+}
+
+func TestValidateMatch(t *testing.T) {
+	for _, m := range []*Match{
+		{Methods: []string{" "}},
+		{Headers: []ValueMatch{{Value: "x"}}},
+		{Headers: []ValueMatch{{Name: "X", Value: "a", Regex: "a"}}},
+		{Query: []ValueMatch{{Name: "q", Regex: "("}}},
+	} {
+		if err := ValidateMatch(m); err == nil {
+			t.Errorf("%+v: expected an error", m)
+		}
+		r := NewRouter().AddWithOptions(Host("api.com"), "/", []*url.URL{mustParseURL("http://localhost:9001")}, RouteOptions{Match: m})
+		if len(r.Routes()) != 0 {
+			t.Errorf("%+v: invalid match was added", m)
+		}
+	}
+	if err := ValidateMatch(nil); err != nil {
+		t.Errorf("nil match: %v", err)
+	}
+}
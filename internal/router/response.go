@@ -0,0 +1,250 @@
+package router
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Redirect answers a route's requests with a redirect instead of forwarding
+// them. With the route "/old/*" and the request "/old/a/b?x=1":
+//
+//	URL: "/new"                                          "/new"
+//	URL: "/new", PreservePath: true                      "/new/a/b"
+//	URL: "https://example.com", PreservePath: true,
+//	PreserveQuery: true                                  "https://example.com/a/b?x=1"
+type Redirect struct {
+	// URL is the target, absolute or a path on the same host.
+	URL string `json:"url" yaml:"url"`
+	// Status is 301, 302, 303, 307 or 308; 301 when zero.
+	Status int `json:"status,omitempty" yaml:"status"`
+	// PreservePath appends the request path with the route's prefix
+	// stripped, as it would have been forwarded to a backend.
+	PreservePath bool `json:"preserve_path,omitempty" yaml:"preserve_path"`
+	// PreserveQuery appends the request's query to the target's.
+	PreserveQuery bool `json:"preserve_query,omitempty" yaml:"preserve_query"`
+
+	target *url.URL
+}
+
+func (rd *Redirect) compile() (*Redirect, error) {
+	if rd == nil {
+		return nil, nil
+	}
+	out := *rd
+	if out.URL == "" {
+		return nil, errors.New("redirect: url is required")
+	}
+	target, err := url.Parse(out.URL)
+	if err != nil {
+		return nil, fmt.Errorf("redirect: %w", err)
+	}
+	out.target = target
+	switch out.Status {
+	case 0:
+		out.Status = http.StatusMovedPermanently
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("redirect: status %d is not a redirect", out.Status)
+	}
+	return &out, nil
+}
+
+// String returns the canonical form of rd, "" for nil.
+func (rd *Redirect) String() string {
+	if rd == nil {
+		return ""
+	}
+	s := fmt.Sprintf("redirect:%d %s", rd.Status, rd.URL)
+	if rd.PreservePath {
+		s += " +path"
+	}
+	if rd.PreserveQuery {
+		s += " +query"
+	}
+	return s
+}
+
+// DirectResponse answers a route's requests with a fixed response instead
+// of forwarding them, e.g. for robots.txt or a maintenance page.
+type DirectResponse struct {
+	// Status is the response status, 200 when zero.
+	Status int `json:"status,omitempty" yaml:"status"`
+	// Headers are set on the response. Content-Type is sniffed from the
+	// body when not given.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers"`
+	// Body is the response body. File names a file to read it from
+	// instead; it is read once, when the route is added or by
+	// LoadResponse. A relative File is resolved against the working
+	// directory.
+	Body string `json:"body,omitempty" yaml:"body"`
+	File string `json:"file,omitempty" yaml:"file"`
+
+	content []byte
+}
+
+func (dr *DirectResponse) compile() (*DirectResponse, error) {
+	if dr == nil {
+		return nil, nil
+	}
+	out := *dr
+	switch {
+	case out.Status == 0:
+		out.Status = http.StatusOK
+	case out.Status < 100 || out.Status > 599:
+		return nil, fmt.Errorf("response: invalid status %d", out.Status)
+	}
+	for name, value := range out.Headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("response: invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("response: invalid value for header %s", name)
+		}
+	}
+	switch {
+	case out.Body != "" && out.File != "":
+		return nil, errors.New("response: body and file are mutually exclusive")
+	case out.File != "":
+		if out.content != nil {
+			break // already loaded
+		}
+		content, err := os.ReadFile(out.File)
+		if err != nil {
+			return nil, fmt.Errorf("response: %w", err)
+		}
+		out.content = content
+	default:
+		out.content = []byte(out.Body)
+	}
+	return &out, nil
+}
+
+// String returns the canonical form of dr, "" for nil. A body file is
+// identified by its name and a hash of the content loaded from it, so
+// responses loaded before and after an edit differ.
+func (dr *DirectResponse) String() string {
+	if dr == nil {
+		return ""
+	}
+	names := make([]string, 0, len(dr.Headers))
+	for name := range dr.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "response:%d", dr.Status)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=%q", name, dr.Headers[name])
+	}
+	if dr.File != "" {
+		fmt.Fprintf(&b, " file=%s sha256=%x", dr.File, sha256.Sum256(dr.content))
+	} else {
+		fmt.Fprintf(&b, " body=%q", dr.Body)
+	}
+	return b.String()
+}
+
+// ValidateRedirect reports whether rd is a usable redirect.
+func ValidateRedirect(rd *Redirect) error {
+	_, err := rd.compile()
+	return err
+}
+
+// ValidateResponse reports whether dr is a usable direct response; a body
+// file must be readable.
+func ValidateResponse(dr *DirectResponse) error {
+	_, err := dr.compile()
+	return err
+}
+
+// LoadResponse returns a copy of dr with its defaults filled in and its body
+// file read, nil for nil. Routes added with the copy serve the content read
+// here.
+func LoadResponse(dr *DirectResponse) (*DirectResponse, error) {
+	return dr.compile()
+}
+
+// validateDirect checks that a route has backends, or is discovered, or
+// answers requests itself, but not both, and that options only the proxy
+// uses are left out of the latter.
+func validateDirect(services []*url.URL, opts RouteOptions) error {
+	switch {
+	case opts.Redirect != nil && opts.Response != nil:
+		return errors.New("redirect and response are mutually exclusive")
+	case opts.Redirect != nil || opts.Response != nil:
+		if len(services) > 0 || opts.Discovered {
+			return errors.New("redirect and response routes take no backends")
+		}
+		if opts.Rewrite != nil {
+			return errors.New("redirect and response routes take no rewrite")
+		}
+		if opts.Headers != nil && opts.Headers.Request != nil {
+			return errors.New("redirect and response routes take no request headers")
+		}
+		if opts.HostHeader != "" {
+			return errors.New("redirect and response routes take no host header")
+		}
+	case len(services) == 0 && !opts.Discovered:
+		return errors.New("no backends")
+	}
+	return nil
+}
+
+// Direct reports whether the route answers requests itself, with a
+// Redirect or a DirectResponse, rather than forwarding them to its pool.
+func (r Route) Direct() bool { return r.Redirect != nil || r.Response != nil }
+
+// RedirectURL returns the Location for a request to u on a redirect route.
+func (r Route) RedirectURL(u *url.URL) string {
+	rd := r.Redirect
+	target := *rd.target
+	if rd.PreservePath {
+		target.Path = joinPath(target.Path, stripPrefix(u.Path, r.Prefix))
+		target.RawPath = ""
+	}
+	if rd.PreserveQuery && u.RawQuery != "" {
+		if target.RawQuery != "" {
+			target.RawQuery += "&"
+		}
+		target.RawQuery += u.RawQuery
+	}
+	return target.String()
+}
+
+// ServeDirect writes the redirect or direct response of the route for the
+// request of c. The route's response header rules run after the response's
+// own headers are set, so they can override them; a redirect's Location is
+// always its target.
+func (r Route) ServeDirect(w http.ResponseWriter, c HeaderContext) {
+	var rules *HeaderRules
+	if r.Headers != nil {
+		rules = r.Headers.Response
+	}
+	req := c.Request
+	if r.Redirect != nil {
+		rules.Apply(w.Header(), c)
+		http.Redirect(w, req, r.RedirectURL(req.URL), r.Redirect.Status)
+		return
+	}
+	dr := r.Response
+	for name, value := range dr.Headers {
+		w.Header().Set(name, value)
+	}
+	if w.Header().Get("Content-Type") == "" && len(dr.content) > 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(dr.content))
+	}
+	rules.Apply(w.Header(), c)
+	w.WriteHeader(dr.Status)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(dr.content)
+	}
+}
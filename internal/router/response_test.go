@@ -0,0 +1,131 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRedirectURL(t *testing.T) {
+	cases := []struct {
+		route    string
+		redirect Redirect
+		request  string
+		want     string
+	}{
+		{"/old/*", Redirect{URL: "/new"}, "/old/a/b?x=1", "/new"},
+		{"/old/*", Redirect{URL: "/new", PreservePath: true}, "/old/a/b?x=1", "/new/a/b"},
+		{"/old/*", Redirect{URL: "/new?v=2", PreserveQuery: true}, "/old/a?x=1", "/new?v=2&x=1"},
+		{"/old/*", Redirect{URL: "https://example.com", PreservePath: true, PreserveQuery: true}, "/old/a/b?x=1", "https://example.com/a/b?x=1"},
+		{"/old", Redirect{URL: "https://example.com/new", PreservePath: true}, "/old", "https://example.com/new"},
+	}
+	for _, c := range cases {
+		rd := c.redirect
+		r := NewRouter().AddWithOptions(Host("a.com"), c.route, nil, RouteOptions{Redirect: &rd})
+		u, _ := url.Parse(c.request)
+		route, _, ok := r.Lookup(Host("a.com"), u.Path)
+		if !ok {
+			t.Errorf("%s %s: no match", c.route, c.request)
+			continue
+		}
+		if got := route.RedirectURL(u); got != c.want {
+			t.Errorf("%s %+v %s: got %s, want %s", c.route, c.redirect, c.request, got, c.want)
+		}
+	}
+}
+
+func TestServeDirect(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "robots.txt")
+	if err := os.WriteFile(file, []byte("User-agent: *\nDisallow: /\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter()
+	r = r.AddWithOptions(Host("a.com"), "/old", nil, RouteOptions{Redirect: &Redirect{URL: "/new", Status: http.StatusFound}})
+	r = r.AddWithOptions(Host("a.com"), "/robots.txt", nil, RouteOptions{Response: &DirectResponse{File: file}})
+	r = r.AddWithOptions(Host("a.com"), "/maintenance", nil, RouteOptions{Response: &DirectResponse{
+		Status:  http.StatusServiceUnavailable,
+		Headers: map[string]string{"Retry-After": "120", "Content-Type": "text/plain"},
+		Body:    "back soon",
+	}})
+	if len(r.healthcheckers) != 0 {
+		t.Errorf("direct routes should not have health checkers, got %d", len(r.healthcheckers))
+	}
+
+	cases := []struct {
+		path, status, header, value, body string
+	}{
+		{"/old", "302", "Location", "/new", ""},
+		{"/robots.txt", "200", "Content-Type", "text/plain; charset=utf-8", "User-agent: *\nDisallow: /\n"},
+		{"/maintenance", "503", "Retry-After", "120", "back soon"},
+	}
+	for _, c := range cases {
+		route, _, ok := r.Lookup(Host("a.com"), c.path)
+		if !ok || !route.Direct() || route.Pool != nil {
+			t.Errorf("%s: expected a direct route without a pool", c.path)
+			continue
+		}
+		w := httptest.NewRecorder()
+		route.ServeDirect(w, HeaderContext{Request: httptest.NewRequest(http.MethodGet, c.path, nil)})
+		if got := w.Result().Status[:3]; got != c.status {
+			t.Errorf("%s: status %s, want %s", c.path, got, c.status)
+		}
+		if got := w.Header().Get(c.header); got != c.value {
+			t.Errorf("%s: %s %q, want %q", c.path, c.header, got, c.value)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("%s: body %q, want %q", c.path, w.Body.String(), c.body)
+		}
+	}
+}
+
+func TestDirectRouteReplacesPool(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r := NewRouter().Add(Host("a.com"), "/old", backend)
+	next, hc := r.Replace(Host("a.com"), "/old", nil, RouteOptions{Redirect: &Redirect{URL: "/new"}})
+	if hc == nil {
+		t.Error("expected the pool route's health checker back")
+	}
+	if len(next.healthcheckers) != 0 {
+		t.Errorf("health checker of the replaced route kept")
+	}
+}
+
+func TestInsertDirectRoutes(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	for name, c := range map[string]struct {
+		services []*url.URL
+		opts     RouteOptions
+	}{
+		"no backends":       {nil, RouteOptions{}},
+		"discovered direct": {nil, RouteOptions{Discovered: true, Response: &DirectResponse{Body: "x"}}},
+		"redirect backends": {backend, RouteOptions{Redirect: &Redirect{URL: "/new"}}},
+		"both":              {nil, RouteOptions{Redirect: &Redirect{URL: "/new"}, Response: &DirectResponse{}}},
+		"rewrite":           {nil, RouteOptions{Redirect: &Redirect{URL: "/new"}, Rewrite: &Rewrite{Keep: true}}},
+		"no url":            {nil, RouteOptions{Redirect: &Redirect{}}},
+		"bad status":        {nil, RouteOptions{Redirect: &Redirect{URL: "/new", Status: 200}}},
+		"body and file":     {nil, RouteOptions{Response: &DirectResponse{Body: "x", File: "x.txt"}}},
+		"missing file":      {nil, RouteOptions{Response: &DirectResponse{File: filepath.Join(t.TempDir(), "none")}}},
+		"bad header":        {nil, RouteOptions{Response: &DirectResponse{Headers: map[string]string{"Bad Name": "x"}}}},
+		"bad header value":  {nil, RouteOptions{Response: &DirectResponse{Headers: map[string]string{"X-A": "a\r\nX-B: 1"}}}},
+	} {
+		if _, err := NewRouter().Insert(Host("a.com"), "/old", c.services, c.opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if r := NewRouter().AddWithOptions(Host("a.com"), "/old", c.services, c.opts); len(r.Routes()) != 0 {
+			t.Errorf("%s: invalid route was added", name)
+		}
+	}
+	if _, err := NewRouter().Insert(Host("a.com"), "/robots.txt", nil, RouteOptions{Response: &DirectResponse{Body: "x"}}); err != nil {
+		t.Errorf("valid response rejected: %v", err)
+	}
+	r, err := NewRouter().Insert(Host("a.com"), "/api", nil, RouteOptions{Discovered: true})
+	if err != nil {
+		t.Fatalf("discovered route without backends rejected: %v", err)
+	}
+	if route, _, ok := r.Lookup(Host("a.com"), "/api"); !ok || route.Pool == nil || len(route.Pool.List()) != 0 {
+		t.Errorf("expected an empty pool, got %+v", route)
+	}
+}
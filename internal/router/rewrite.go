@@ -0,0 +1,193 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rewrite turns the request path into the path sent to the backend. At most
+// one mode may be set; a nil or empty Rewrite strips the route's prefix.
+//
+// With the route "/api/*" and the request path "/api/users/7":
+//
+//	(none)                                   "/users/7"
+//	Keep: true                               "/api/users/7"
+//	Prefix: "/v2"                            "/v2/users/7"
+//	Regex: "^/api/(.*)", Replacement: "/$1"  "/users/7"
+//
+// and with the route "/users/:id" and the request path "/users/7":
+//
+//	Template: "/v2/accounts/{id}"            "/v2/accounts/7"
+type Rewrite struct {
+	// Keep forwards the original path unchanged.
+	Keep bool `json:"keep,omitempty" yaml:"keep"`
+	// Prefix replaces the matched prefix, as if stripping it and prepending
+	// Prefix.
+	Prefix string `json:"prefix,omitempty" yaml:"prefix"`
+	// Regex is applied to the whole original path and every match replaced
+	// with Replacement, which may refer to capture groups as $1 or ${name}.
+	// Paths that do not match are forwarded unchanged.
+	Regex       string `json:"regex,omitempty" yaml:"regex"`
+	Replacement string `json:"replacement,omitempty" yaml:"replacement"`
+	// Template builds the path from the route's parameters, written {name};
+	// a named wildcard's parameter holds the rest of the path.
+	Template string `json:"template,omitempty" yaml:"template"`
+
+	re *regexp.Regexp
+}
+
+// compile validates rw against the route path and returns a copy ready for
+// use. A nil or empty Rewrite compiles to nil.
+func (rw *Rewrite) compile(path string) (*Rewrite, error) {
+	if rw == nil || *rw == (Rewrite{}) {
+		return nil, nil
+	}
+
+	modes := 0
+	for _, set := range []bool{rw.Keep, rw.Prefix != "", rw.Regex != "", rw.Template != ""} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return nil, errors.New("rewrite: keep, prefix, regex and template are mutually exclusive")
+	}
+	if rw.Replacement != "" && rw.Regex == "" {
+		return nil, errors.New("rewrite: replacement requires regex")
+	}
+
+	out := *rw
+	if rw.Regex != "" {
+		re, err := regexp.Compile(rw.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite: %w", err)
+		}
+		out.re = re
+	}
+	if rw.Template != "" {
+		names := paramNames(path)
+		for _, name := range templateNames(rw.Template) {
+			if name == "" || !names[name] {
+				return nil, fmt.Errorf("rewrite: template refers to unknown parameter {%s}", name)
+			}
+		}
+	}
+	return &out, nil
+}
+
+// ValidateRewrite reports whether rw can be used on a route with path.
+func ValidateRewrite(path string, rw *Rewrite) error {
+	_, err := rw.compile(path)
+	return err
+}
+
+// String returns the canonical form of rw, "" when it only strips the prefix.
+func (rw *Rewrite) String() string {
+	switch {
+	case rw == nil:
+		return ""
+	case rw.Keep:
+		return "keep"
+	case rw.Prefix != "":
+		return "prefix:" + rw.Prefix
+	case rw.Regex != "":
+		return "regex:" + rw.Regex + " => " + rw.Replacement
+	case rw.Template != "":
+		return "template:" + rw.Template
+	}
+	return ""
+}
+
+func templateNames(tmpl string) []string {
+	var names []string
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start == -1 {
+			return names
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end == -1 {
+			return append(names, "")
+		}
+		names = append(names, tmpl[start+1:start+end])
+		tmpl = tmpl[start+end+1:]
+	}
+}
+
+// BackendPath returns the path to forward a request for path to, given the
+// parameters the route captured. See Rewrite.
+func (r Route) BackendPath(path string, params Params) string {
+	rw := r.Rewrite
+	switch {
+	case rw == nil:
+		return ensureSlash(stripPrefix(path, r.Prefix))
+	case rw.Keep:
+		return ensureSlash(path)
+	case rw.Prefix != "":
+		return joinPath(rw.Prefix, stripPrefix(path, r.Prefix))
+	case rw.re != nil:
+		return ensureSlash(rw.re.ReplaceAllString(path, rw.Replacement))
+	case rw.Template != "":
+		var b strings.Builder
+		tmpl := rw.Template
+		for {
+			start := strings.IndexByte(tmpl, '{')
+			if start == -1 {
+				b.WriteString(tmpl)
+				break
+			}
+			end := strings.IndexByte(tmpl[start:], '}')
+			b.WriteString(tmpl[:start])
+			b.WriteString(params.Get(tmpl[start+1 : start+end]))
+			tmpl = tmpl[start+end+1:]
+		}
+		return ensureSlash(b.String())
+	}
+	return ensureSlash(stripPrefix(path, r.Prefix))
+}
+
+// stripPrefix strips the matched prefix before forwarding:
+//
+//	External request:  /api/v1/users/123
+//	Route prefix:      /api/v1
+//	Backend receives:  /users/123
+//
+// This allows the services to define routes without the public prefix.
+// For wildcard routes, we strip everything up to the wildcard.
+func stripPrefix(path, prefix string) string {
+	// Wildcard prefixes may contain parameters, so strip by segment count:
+	// the path matched the route, so its first segments are the prefix's.
+	if i := strings.LastIndex(prefix, "/"); i != -1 && strings.HasPrefix(prefix[i+1:], "*") {
+		drop := 0
+		if base := strings.Trim(prefix[:i], "/"); base != "" {
+			drop = strings.Count(base, "/") + 1
+		}
+		rest := strings.TrimPrefix(path, "/")
+		for ; drop > 0 && rest != ""; drop-- {
+			j := strings.IndexByte(rest, '/')
+			if j == -1 {
+				rest = ""
+				break
+			}
+			rest = strings.TrimLeft(rest[j+1:], "/")
+		}
+		return "/" + rest
+	}
+	return strings.TrimPrefix(path, prefix)
+}
+
+func joinPath(prefix, rest string) string {
+	if rest == "" || rest == "/" {
+		return ensureSlash(prefix)
+	}
+	return strings.TrimSuffix(ensureSlash(prefix), "/") + ensureSlash(rest)
+}
+
+func ensureSlash(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return "/" + p
+	}
+	return p
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestBackendPath(t *testing.T) {
+	cases := []struct {
+		route   string
+		rewrite *Rewrite
+		path    string
+		want    string
+	}{
+		{"/api/*", nil, "/api/users/7", "/users/7"},
+		{"/api/*", nil, "/api", "/"},
+		{"/api", nil, "/api", "/"},
+		{"/api/*", &Rewrite{Keep: true}, "/api/users/7", "/api/users/7"},
+		{"/api/*", &Rewrite{Prefix: "/v2"}, "/api/users/7", "/v2/users/7"},
+		{"/api/*", &Rewrite{Prefix: "/v2/"}, "/api", "/v2/"},
+		{"/api/*", &Rewrite{Regex: "^/api/(\\w+)/(\\d+)$", Replacement: "/$1/by-id/$2"}, "/api/users/7", "/users/by-id/7"},
+		{"/api/*", &Rewrite{Regex: "^/api/(?P<rest>.*)", Replacement: "/internal/${rest}"}, "/api/a/b", "/internal/a/b"},
+		{"/api/*", &Rewrite{Regex: "^/nomatch", Replacement: "/x"}, "/api/a", "/api/a"},
+		{"/users/:id", &Rewrite{Template: "/v2/accounts/{id}"}, "/users/7", "/v2/accounts/7"},
+		{"/files/:owner/*rest", &Rewrite{Template: "/{owner}/{rest}"}, "/files/bob/a/b.txt", "/bob/a/b.txt"},
+	}
+	for _, c := range cases {
+		r := NewRouter().AddWithOptions(Host("a.com"), c.route, []*url.URL{mustParseURL("http://localhost:9000")}, RouteOptions{Rewrite: c.rewrite})
+		route, params, ok := r.Lookup(Host("a.com"), c.path)
+		if !ok {
+			t.Errorf("%s %s: no match", c.route, c.path)
+			continue
+		}
+		if got := route.BackendPath(c.path, params); got != c.want {
+			t.Errorf("%s %v %s: got %s, want %s", c.route, c.rewrite, c.path, got, c.want)
+		}
+	}
+}
+
+func TestValidateRewrite(t *testing.T) {
+	for _, c := range []struct {
+		path    string
+		rewrite *Rewrite
+	}{
+		{"/a/*", &Rewrite{Keep: true, Prefix: "/b"}},
+		{"/a/*", &Rewrite{Replacement: "/b"}},
+		{"/a/*", &Rewrite{Regex: "("}},
+		{"/a/:id", &Rewrite{Template: "/b/{name}"}},
+		{"/a/:id", &Rewrite{Template: "/b/{id"}},
+	} {
+		if err := ValidateRewrite(c.path, c.rewrite); err == nil {
+			t.Errorf("%s %+v: expected an error", c.path, c.rewrite)
+		}
+		r := NewRouter().AddWithOptions(Host("a.com"), c.path, []*url.URL{mustParseURL("http://localhost:9000")}, RouteOptions{Rewrite: c.rewrite})
+		if len(r.Routes()) != 0 {
+			t.Errorf("%s %+v: invalid rewrite was added", c.path, c.rewrite)
+		}
+	}
+	if err := ValidateRewrite("/a/:id", &Rewrite{Template: "/b/{id}"}); err != nil {
+		t.Errorf("valid template rejected: %v", err)
+	}
+}
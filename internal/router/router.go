@@ -1,8 +1,12 @@
 package router
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"net/netip"
 	"net/url"
+	"sort"
 	"strings"
 	"sync/atomic"
 
@@ -10,18 +14,54 @@ import (
 	"github.com/diabeney/balto/internal/core/backendpool"
 	"github.com/diabeney/balto/internal/core/balancer"
 	"github.com/diabeney/balto/internal/health"
+	"github.com/diabeney/balto/pkg/pubsub/pubsub"
 )
 
+// TopicReload carries a ReloadEvent whenever a new router becomes current.
+const TopicReload pubsub.Topic = "router.reload"
+
+type ReloadEvent struct {
+	Hosts  int `json:"hosts"`
+	Routes int `json:"routes"`
+}
+
+func (e ReloadEvent) String() string {
+	return fmt.Sprintf("router reloaded: %d host(s), %d route(s)", e.Hosts, e.Routes)
+}
+
 type InitialRoutes struct {
 	Domain     string   `json:"domain" yaml:"domain"`
 	PathPrefix string   `json:"path_prefix" yaml:"path_prefix"`
 	Ports      []string `json:"ports" yaml:"ports"`
+	Match      *Match   `json:"match,omitempty" yaml:"match"`
+	Rewrite    *Rewrite `json:"rewrite,omitempty" yaml:"rewrite"`
+	Headers    *Headers `json:"headers,omitempty" yaml:"headers"`
+	// HostHeader is "backend" (default), "preserve" or a fixed host.
+	HostHeader HostHeader `json:"host_header,omitempty" yaml:"host_header"`
+
+	// Redirect or Response make the route answer requests itself; Ports
+	// must then be empty.
+	Redirect *Redirect       `json:"redirect,omitempty" yaml:"redirect"`
+	Response *DirectResponse `json:"response,omitempty" yaml:"response"`
 }
 
 type Host string
 
+// DefaultHost is the host pattern of routes that answer requests no other
+// host matches, e.g. requests for a raw IP.
+const DefaultHost Host = "*"
+
 func (h Host) lower() Host { return Host(strings.ToLower(string(h))) }
 
+// wildcardSuffix returns "example.com" for the pattern "*.example.com".
+// Wildcards match any subdomain, at any depth, but not the domain itself.
+func (h Host) wildcardSuffix() (Host, bool) {
+	if len(h) > 2 && h[0] == '*' && h[1] == '.' {
+		return h[2:], true
+	}
+	return "", false
+}
+
 func (h Host) normalize() Host {
 	host := string(h.lower())
 	// Remove port if present
@@ -34,156 +74,153 @@ func (h Host) normalize() Host {
 type Route struct {
 	Prefix string
 	Pool   *backendpool.Pool
+
+	// Rewrite turns the request path into the backend path, see
+	// BackendPath. Nil strips Prefix.
+	Rewrite *Rewrite
+
+	// Match holds the request conditions of the route, nil when it matches
+	// every request for its path.
+	Match *Match
+
+	// Headers edits the route's request and response headers, nil when
+	// it has no rules.
+	Headers *Headers
+
+	// HostHeader selects the Host sent to backends, see UpstreamHost.
+	HostHeader HostHeader
+
+	// Redirect and Response are set on routes that answer requests
+	// themselves, see Direct. Such routes have no Pool.
+	Redirect *Redirect
+	Response *DirectResponse
 }
 
+// Key returns the key the route is registered under on host.
+func (r Route) Key(host Host) string { return MatchKey(host, r.Prefix, r.Match) }
+
 func (r Route) NextBackend() (*core.Backend, error) {
+	return r.NextBackendFor(netip.Addr{})
+}
+
+// NextBackendFor is NextBackend for a request from client, see
+// backendpool.Pool.NextFor.
+func (r Route) NextBackendFor(client netip.Addr) (*core.Backend, error) {
 	if r.Pool == nil {
 		return nil, fmt.Errorf("no backend pool")
 	}
-	backend := r.Pool.Next()
+	backend := r.Pool.NextFor(client)
 	if backend == nil {
 		return nil, fmt.Errorf("no healthy backend available")
 	}
 	return backend, nil
 }
 
-type Params map[string]string
-
-type node struct {
-	segment    string
-	route      *Route
-	paramName  string
-	isWildcard bool
-	children   map[string]*node
+type Router struct {
+	hosts          map[Host]*node
+	wildcards      map[Host]*node // by suffix: "*.example.com" is stored as "example.com"
+	defaultHost    *node
+	healthcheckers map[string]*health.Healthchecker
+	maxParams      int // most parameters any route captures
 }
 
-func newNode(seg string) *node {
-	n := &node{
-		segment:  seg,
-		children: make(map[string]*node),
-	}
-
-	if len(seg) > 1 && seg[0] == ':' {
-		n.paramName = seg[1:]
-	} else if seg == "*" {
-		n.isWildcard = true
+func NewRouter() *Router {
+	return &Router{
+		hosts:          make(map[Host]*node),
+		wildcards:      make(map[Host]*node),
+		healthcheckers: make(map[string]*health.Healthchecker),
 	}
-	return n
 }
 
-// insert adds a new route to the node tree while preserving immutability.
-// It uses a copy-on-write approach, which performs a shallow copy of the current
-// node and its children map. Unchanged subtrees are reused, while only modified
-// branches are cloned. This allows multiple router versions to coexist safely,
-// sharing unchanged nodes without affecting previous instances.
-
-func (n *node) insert(segments []string, route *Route) *node {
-	if len(segments) == 0 {
-		copied := *n
-		copied.route = route
-		return &copied
+// root returns the tree registered for the host pattern h.
+func (r *Router) root(h Host) *node {
+	if h == DefaultHost {
+		return r.defaultHost
 	}
-
-	seg := segments[0]
-	copied := *n
-	copied.children = copyMap(n.children) // Shallow copy: new map, shared node pointers
-
-	child, exists := copied.children[seg]
-	if !exists {
-		child = newNode(seg)
-		copied.children[seg] = child
-	}
-
-	if len(segments) == 1 {
-		newChild := *child
-		newChild.route = route
-		copied.children[seg] = &newChild
-	} else {
-		copied.children[seg] = child.insert(segments[1:], route)
+	if suffix, ok := h.wildcardSuffix(); ok {
+		return r.wildcards[suffix]
 	}
-	return &copied
+	return r.hosts[h]
 }
 
-func (n *node) lookup(segments []string, params Params) (*Route, bool) {
-	if len(segments) == 0 {
-		if n.route != nil {
-			return n.route, true
+// withRoot returns a copy of r with the tree for the host pattern h
+// replaced, or removed if root is nil. Trees of other hosts are shared.
+func (r *Router) withRoot(h Host, root *node) *Router {
+	next := &Router{
+		hosts:          r.hosts,
+		wildcards:      r.wildcards,
+		defaultHost:    r.defaultHost,
+		healthcheckers: r.healthcheckers,
+		maxParams:      r.maxParams,
+	}
+	switch suffix, wildcard := h.wildcardSuffix(); {
+	case h == DefaultHost:
+		next.defaultHost = root
+	case wildcard:
+		next.wildcards = copyHostMap(r.wildcards)
+		next.wildcards[suffix] = root
+		if root == nil {
+			delete(next.wildcards, suffix)
 		}
-		if child, ok := n.children["*"]; ok && child.route != nil {
-			return child.route, true
-		}
-		return nil, false
-	}
-
-	seg := segments[0]
-
-	// Try to match the segment exactly.
-	if child, ok := n.children[seg]; ok {
-		if r, found := child.lookup(segments[1:], params); found {
-			return r, true
+	default:
+		next.hosts = copyHostMap(r.hosts)
+		next.hosts[h] = root
+		if root == nil {
+			delete(next.hosts, h)
 		}
 	}
+	return next
+}
 
-	// Try to match the segment as a parameter.
-	for paramSeg, child := range n.children {
-		if len(paramSeg) > 1 && paramSeg[0] == ':' {
-			params[child.paramName] = seg
-			if r, found := child.lookup(segments[1:], params); found {
-				return r, true
+// matchHost returns the tree serving host: an exact match first, then the
+// most specific wildcard, then the default host.
+func (r *Router) matchHost(host Host) *node {
+	if root := r.hosts[host]; root != nil {
+		return root
+	}
+	if len(r.wildcards) > 0 {
+		s := string(host)
+		for i := strings.IndexByte(s, '.'); i != -1; i = strings.IndexByte(s, '.') {
+			s = s[i+1:]
+			if root := r.wildcards[Host(s)]; root != nil {
+				return root
 			}
-			delete(params, child.paramName)
 		}
 	}
+	return r.defaultHost
+}
 
-	// Try to match the segment as a wildcard.
-	if child, ok := n.children["*"]; ok && child.isWildcard && child.route != nil {
-		return child.route, true
+// ValidateHost reports whether h is a usable host pattern: an exact host,
+// a "*." wildcard or DefaultHost.
+func ValidateHost(h Host) error {
+	if h == "" {
+		return errors.New("empty host")
 	}
-
-	/*
-		!INFO:
-		When no matching child segment is found, we intentionally avoid returning the
-		current node’s route even if it exists. Allowing that fallback could cause
-		unexpected behavior. For example, if a route is registered for "/api" and a
-		request comes in for "/api/v1", we shouldn’t return the "/api" route unless
-		a wildcard (e.g. "/*" or "/api/*") was explicitly configured to match it.
-		This ensures routes are matched strictly unless a catch-all is defined.
-	*/
-
-	return nil, false
+	if h == DefaultHost {
+		return nil
+	}
+	rest := string(h)
+	if suffix, ok := h.wildcardSuffix(); ok {
+		rest = string(suffix)
+	}
+	if strings.Contains(rest, "*") {
+		return fmt.Errorf("invalid host pattern %q: only a leading \"*.\" is supported", h)
+	}
+	return nil
 }
 
-func copyMap(m map[string]*node) map[string]*node {
-	c := make(map[string]*node, len(m))
+func copyHostMap(m map[Host]*node) map[Host]*node {
+	c := make(map[Host]*node, len(m)+1)
 	for k, v := range m {
 		c[k] = v
 	}
 	return c
 }
 
-type Router struct {
-	hosts          map[Host]*node
-	healthcheckers map[string]*health.Healthchecker
-}
-
-func NewRouter() *Router {
-	return &Router{
-		hosts:          make(map[Host]*node),
-		healthcheckers: make(map[string]*health.Healthchecker),
-	}
-}
-
-func (r *Router) Add(host Host, path string, services []*url.URL) *Router {
-	if host == "" || len(services) == 0 {
-		return r
-	}
-
-	h := host.normalize()
-	normPath := normalizePrefix(path)
-	segments := pathToSegments(normPath)
-
-	bal := balancer.NewRoundRobin()
-	poolCfg := &backendpool.PoolConfig{
+// DefaultPoolConfig returns the pool settings used for routes that do not
+// provide their own.
+func DefaultPoolConfig() backendpool.PoolConfig {
+	return backendpool.PoolConfig{
 		HealthThreshold:            10,
 		ProbeHealthThreshold:       10,
 		ProbeRecoveryThreshold:     5,
@@ -196,55 +233,261 @@ func (r *Router) Add(host Host, path string, services []*url.URL) *Router {
 		CircuitMaxHalfOpenRequests: 5,
 		Retry:                      10,
 	}
+}
 
-	pool := backendpool.New(poolCfg, bal)
+// RouteOptions customizes a route beyond its host, path and backends.
+type RouteOptions struct {
+	// Pool replaces DefaultPoolConfig for the route's backend pool.
+	// ServiceName is always set to the route key.
+	Pool *backendpool.PoolConfig
 
-	for _, u := range services {
-		id := fmt.Sprintf("%s-%s", h, u.String())
-		pool.Add(id, u, 1)
-	}
+	// Rewrite sets Route.Rewrite; Rewrite{Keep: true} forwards the original
+	// path.
+	Rewrite *Rewrite
+
+	// Match restricts the route to matching requests. Routes on the same
+	// host and path with different conditions coexist, each with its own
+	// pool; adding one with identical conditions replaces it.
+	Match *Match
+
+	// Headers sets Route.Headers. Routes with Redirect or Response may only
+	// edit response headers.
+	Headers *Headers
+
+	// HostHeader sets Route.HostHeader.
+	HostHeader HostHeader
 
-	hc := health.New(pool)
+	// Redirect or Response turn the route into one that answers requests
+	// itself, without backends or a pool. At most one may be set.
+	Redirect *Redirect
+	Response *DirectResponse
 
-	newHosts := make(map[Host]*node, len(r.hosts)+1)
-	for k, v := range r.hosts {
-		newHosts[k] = v
+	// Discovered lets a pool route start without backends; a discovery
+	// provider fills the pool later. Until then requests get no backend.
+	Discovered bool
+}
+
+// Add returns a router with an extra route. host is an exact host, a
+// wildcard such as "*.example.com" matching any subdomain, or DefaultHost;
+// invalid patterns leave the router unchanged. A request is served by its
+// exact host if registered, else by the longest matching wildcard, else by
+// the default host. Only the chosen host's routes are consulted.
+//
+// A route with the same host, path and conditions as an existing one
+// replaces it, as does one whose catch-all is merely named differently, e.g.
+// "/files/*rest" for "/files/*path". The replaced route's health checker is
+// dropped without being stopped; use Replace to get it back, or Insert to have
+// the duplicate reported instead.
+func (r *Router) Add(host Host, path string, services []*url.URL) *Router {
+	return r.AddWithOptions(host, path, services, RouteOptions{})
+}
+
+func (r *Router) AddWithOptions(host Host, path string, services []*url.URL, opts RouteOptions) *Router {
+	next, _ := r.add(host, path, services, opts)
+	return next
+}
+
+// add is AddWithOptions that also returns the health checker of the route
+// the new one replaced.
+func (r *Router) add(host Host, path string, services []*url.URL, opts RouteOptions) (*Router, *health.Healthchecker) {
+	match, err := opts.Match.compile()
+	if err != nil || ValidateHost(host) != nil || ValidatePath(path) != nil || ValidateHostHeader(opts.HostHeader) != nil {
+		return r, nil
+	}
+	rewrite, err := opts.Rewrite.compile(path)
+	if err != nil {
+		return r, nil
 	}
+	redirect, err := opts.Redirect.compile()
+	if err != nil {
+		return r, nil
+	}
+	response, err := opts.Response.compile()
+	if err != nil {
+		return r, nil
+	}
+	headers, err := opts.Headers.compile(path)
+	if err != nil {
+		return r, nil
+	}
+	direct := redirect != nil || response != nil
+	if validateDirect(services, opts) != nil {
+		return r, nil
+	}
+
+	h := host.normalize()
+	normPath := normalizePrefix(path)
+	tokens := tokenize(normPath)
+
+	routeKey := MatchKey(h, normPath, match)
+
 	newHealthcheckers := make(map[string]*health.Healthchecker, len(r.healthcheckers)+1)
 	for k, v := range r.healthcheckers {
 		newHealthcheckers[k] = v
 	}
 
-	routeKey := fmt.Sprintf("%s%s", h, normPath)
-	newHealthcheckers[routeKey] = hc
+	route := &Route{
+		Prefix: path, Rewrite: rewrite, Match: match, Headers: headers, HostHeader: opts.HostHeader,
+		Redirect: redirect, Response: response,
+	}
+	if direct {
+		delete(newHealthcheckers, routeKey)
+	} else {
+		route.Pool, newHealthcheckers[routeKey] = newPool(h, routeKey, services, opts.Pool)
+	}
 
-	route := &Route{Prefix: path, Pool: pool}
-	root := newHosts[h]
+	root := r.root(h)
 	if root == nil {
-		root = &node{children: make(map[string]*node)}
+		root = &node{}
 	}
-	newRoot := root.insert(segments, route)
-	newHosts[h] = newRoot
 
-	return &Router{
-		hosts:          newHosts,
-		healthcheckers: newHealthcheckers,
+	newRoot, replaced := root.insert(tokens, route)
+	var displaced *health.Healthchecker
+	if replaced != nil {
+		key := replaced.Key(h)
+		displaced = r.healthcheckers[key]
+		if key != routeKey {
+			delete(newHealthcheckers, key)
+		}
+	}
+
+	next := r.withRoot(h, newRoot)
+	next.healthcheckers = newHealthcheckers
+	next.maxParams = max(r.maxParams, countParams(tokens))
+	return next, displaced
+}
+
+// newPool creates the backend pool of a route and its health checker.
+func newPool(h Host, routeKey string, services []*url.URL, cfg *backendpool.PoolConfig) (*backendpool.Pool, *health.Healthchecker) {
+	bal := balancer.NewRoundRobin()
+	poolCfg := DefaultPoolConfig()
+	if cfg != nil {
+		poolCfg = *cfg
+	}
+	poolCfg.ServiceName = routeKey
+
+	pool := backendpool.New(&poolCfg, bal)
+
+	for _, u := range services {
+		pool.Add(BackendID(h, u), u, 1)
+	}
+
+	return pool, health.New(pool)
+}
+
+// Replace is AddWithOptions that also returns the health checker of the
+// route it replaces, nil if there was none, so the caller can stop it once
+// the new router is in use. The new route's health checker is started by
+// calling Start on the returned router.
+func (r *Router) Replace(host Host, path string, services []*url.URL, opts RouteOptions) (*Router, *health.Healthchecker) {
+	return r.add(host, path, services, opts)
+}
+
+// Remove returns a router without the route for host and path that has no
+// request conditions, and that route's health checker, which the caller
+// should stop once the new router is in use. If there is no such route, r
+// and nil are returned.
+func (r *Router) Remove(host Host, path string) (*Router, *health.Healthchecker) {
+	return r.RemoveMatch(host, path, nil)
+}
+
+// RemoveMatch is Remove for the route with conditions m.
+func (r *Router) RemoveMatch(host Host, path string, m *Match) (*Router, *health.Healthchecker) {
+	match, err := m.compile()
+	if err != nil {
+		return r, nil
+	}
+	h := host.normalize()
+	root := r.root(h)
+	if root == nil {
+		return r, nil
+	}
+
+	normPath := normalizePrefix(path)
+	newRoot, removed := root.remove(tokenize(normPath), normPath, match.String())
+	if removed == nil {
+		return r, nil
+	}
+	if newRoot.empty() {
+		newRoot = nil
+	}
+
+	key := removed.Key(h)
+	next := r.withRoot(h, newRoot)
+	next.healthcheckers = make(map[string]*health.Healthchecker, len(r.healthcheckers))
+	for k, v := range r.healthcheckers {
+		if k != key {
+			next.healthcheckers[k] = v
+		}
 	}
+	return next, r.healthcheckers[key]
 }
 
+// Lookup returns the route for host and path among the routes without
+// request conditions. Use Match to consider every route.
 func (r *Router) Lookup(host Host, path string) (Route, Params, bool) {
-	root := r.hosts[host.normalize()]
+	return r.lookup(host, path, nil)
+}
+
+// Match returns the route serving req, taking method, header and query
+// conditions into account. A path whose routes all reject req falls through
+// to less specific paths, as if it had no route.
+func (r *Router) Match(req *http.Request) (Route, Params, bool) {
+	return r.lookup(Host(req.Host), req.URL.Path, req)
+}
+
+func (r *Router) lookup(host Host, path string, req *http.Request) (Route, Params, bool) {
+	root := r.matchHost(host.normalize())
 	if root == nil {
 		return Route{}, nil, false
 	}
 
-	segments := pathToSegments(normalizePrefix(path))
-	params := make(Params)
-	route, found := root.lookup(segments, params)
-	if !found {
+	s := lookupState{req: req, maxParams: r.maxParams}
+	route := root.lookup(cleanPath(path), &s)
+	if route == nil {
 		return Route{}, nil, false
 	}
-	return *route, params, true
+	return *route, s.params, true
+}
+
+// RouteInfo describes a registered route for introspection.
+type RouteInfo struct {
+	Key           string // host + normalized path + conditions, e.g. "example.com/api [method=GET]"
+	Host          Host
+	Route         Route
+	Healthchecker *health.Healthchecker
+}
+
+// RouteKey returns the key a route is registered under.
+func RouteKey(host Host, path string) string {
+	return fmt.Sprintf("%s%s", host.normalize(), normalizePrefix(path))
+}
+
+// BackendID returns the ID a backend added through Add gets in its pool.
+func BackendID(host Host, u *url.URL) string {
+	return fmt.Sprintf("%s-%s", host.normalize(), u.String())
+}
+
+// Routes returns every route in the router, sorted by key.
+func (r *Router) Routes() []RouteInfo {
+	var out []RouteInfo
+	add := func(h Host, root *node) {
+		root.walk(func(route *Route) {
+			key := route.Key(h)
+			out = append(out, RouteInfo{Key: key, Host: h, Route: *route, Healthchecker: r.healthcheckers[key]})
+		})
+	}
+	for h, root := range r.hosts {
+		add(h, root)
+	}
+	for suffix, root := range r.wildcards {
+		add("*."+suffix, root)
+	}
+	if r.defaultHost != nil {
+		add(DefaultHost, r.defaultHost)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
 }
 
 // Start initiates all healthcheckers associated with this router.
@@ -284,8 +527,22 @@ var current atomic.Pointer[Router]
 
 // TODO: When we integrate hot reload fully, we need to make sure we stop the stop the healthchecker for the previour router
 // TODO: before switch to prevent goroutine leaks
-func SetCurrent(r *Router) { current.Store(r) }
-func Current() *Router     { return current.Load() }
+func SetCurrent(r *Router) {
+	current.Store(r)
+	if r != nil {
+		pubsub.Default().Publish(TopicReload, ReloadEvent{Hosts: r.hostCount(), Routes: len(r.healthcheckers)})
+	}
+}
+
+func Current() *Router { return current.Load() }
+
+func (r *Router) hostCount() int {
+	n := len(r.hosts) + len(r.wildcards)
+	if r.defaultHost != nil {
+		n++
+	}
+	return n
+}
 
 func normalizePrefix(p string) string {
 	p = strings.TrimSpace(p)
@@ -325,6 +582,8 @@ func parseServices(ports []string, scheme string) ([]*url.URL, error) {
 	return out, nil
 }
 
+// BuildFromConfig builds a router from cfg, rejecting invalid, duplicate
+// and ambiguous routes.
 func BuildFromConfig(cfg []InitialRoutes) (*Router, error) {
 	r := NewRouter()
 	for _, c := range cfg {
@@ -332,7 +591,14 @@ func BuildFromConfig(cfg []InitialRoutes) (*Router, error) {
 		if err != nil {
 			return nil, err
 		}
-		r = r.Add(Host(c.Domain), c.PathPrefix, services)
+		opts := RouteOptions{
+			Match: c.Match, Rewrite: c.Rewrite, Headers: c.Headers, HostHeader: c.HostHeader,
+			Redirect: c.Redirect, Response: c.Response,
+		}
+		r, err = r.Insert(Host(c.Domain), c.PathPrefix, services, opts)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return r, nil
 }
@@ -3,6 +3,7 @@ package router
 import (
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -25,10 +26,28 @@ func newTestRouter() *Router {
 	return r
 }
 
+func countRoutes(n *node) int {
+	count := 0
+	n.walk(func(*Route) { count++ })
+	return count
+}
+
+// staticNode returns the node reached by exactly the static text path.
+func staticNode(n *node, path string) *node {
+	for path != "" {
+		i := strings.IndexByte(n.indices, path[0])
+		if i == -1 || !strings.HasPrefix(path, n.static[i].prefix) {
+			return nil
+		}
+		n, path = n.static[i], path[len(n.static[i].prefix):]
+	}
+	return n
+}
+
 func TestRouterAdd(t *testing.T) {
 	t.Run("Add route to existing host", func(t *testing.T) {
 		r := newTestRouter()
-		initial := len(r.hosts[Host("www.example.com").lower()].children)
+		initial := countRoutes(r.hosts[Host("www.example.com").lower()])
 
 		r = r.Add(Host("www.example.com"), "/admin", []*url.URL{mustParseURL("http://localhost:5000")})
 
@@ -43,8 +62,8 @@ func TestRouterAdd(t *testing.T) {
 		if backend.URL.String() != "http://localhost:5000" {
 			t.Errorf("got %s, want http://localhost:5000", backend.URL.String())
 		}
-		if len(r.hosts[Host("www.example.com").lower()].children) <= initial {
-			t.Error("child count did not increase")
+		if countRoutes(r.hosts[Host("www.example.com").lower()]) <= initial {
+			t.Error("route count did not increase")
 		}
 	})
 
@@ -150,8 +169,8 @@ func TestRouterLookup(t *testing.T) {
 		if backend.URL.Port() != "3003" {
 			t.Errorf("want backend 3003, got %s", backend.URL.Port())
 		}
-		if params["id"] != "456" {
-			t.Errorf("want id=456, got %v", params["id"])
+		if params.Get("id") != "456" {
+			t.Errorf("want id=456, got %v", params.Get("id"))
 		}
 	})
 
@@ -297,3 +316,214 @@ func TestRouterEdgeCases(t *testing.T) {
 		_ = Current()
 	})
 }
+
+func TestRouterRoutes(t *testing.T) {
+	r := newTestRouter()
+	routes := r.Routes()
+
+	want := []string{
+		"api.example.com/v1",
+		"www.example.com/",
+		"www.example.com/api",
+		"www.example.com/api/v1",
+		"www.example.com/static/*",
+		"www.example.com/users/:id",
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("expected %d routes, got %d", len(want), len(routes))
+	}
+	for i, ri := range routes {
+		if ri.Key != want[i] {
+			t.Errorf("route %d: got key %s, want %s", i, ri.Key, want[i])
+		}
+		if ri.Route.Pool == nil || ri.Route.Pool.Config().ServiceName != ri.Key {
+			t.Errorf("route %s: pool not named after its key", ri.Key)
+		}
+	}
+}
+
+func TestRouterAddWithOptions(t *testing.T) {
+	cfg := DefaultPoolConfig()
+	cfg.ProbePath = "/healthz"
+	cfg.ProbeExpectJSONField = "status"
+	cfg.ProbeExpectJSONValue = "UP"
+
+	r := NewRouter().AddWithOptions(Host("opts.com"), "/api", []*url.URL{mustParseURL("http://localhost:7001")}, RouteOptions{Pool: &cfg})
+	route, _, ok := r.Lookup(Host("opts.com"), "/api")
+	if !ok {
+		t.Fatal("expected /api on opts.com")
+	}
+	got := route.Pool.Config()
+	if got.ProbePath != "/healthz" || got.ProbeExpectJSONValue != "UP" {
+		t.Errorf("pool config not applied: %+v", got)
+	}
+	if got.ServiceName != "opts.com/api" {
+		t.Errorf("expected service name opts.com/api, got %s", got.ServiceName)
+	}
+	if cfg.ServiceName != "" {
+		t.Error("AddWithOptions mutated the caller's config")
+	}
+}
+
+func TestRouterHostPatterns(t *testing.T) {
+	r := NewRouter()
+	r = r.Add(Host("www.example.com"), "/", []*url.URL{mustParseURL("http://localhost:8001")})
+	r = r.Add(Host("*.example.com"), "/", []*url.URL{mustParseURL("http://localhost:8002")})
+	r = r.Add(Host("*.eu.example.com"), "/", []*url.URL{mustParseURL("http://localhost:8003")})
+	r = r.Add(DefaultHost, "/fallback", []*url.URL{mustParseURL("http://localhost:8004")})
+
+	cases := []struct {
+		host string
+		path string
+		want string // matched route key, "" for no match
+	}{
+		{"www.example.com", "/", "www.example.com/"},
+		{"tenant.example.com", "/", "*.example.com/"},
+		{"a.b.example.com:8080", "/", "*.example.com/"},
+		{"shop.eu.example.com", "/", "*.eu.example.com/"},
+		{"eu.example.com", "/", "*.example.com/"},
+		{"example.com", "/fallback", "*/fallback"},
+		{"10.0.0.1", "/fallback", "*/fallback"},
+		// The chosen host alone decides; misses do not fall through.
+		{"tenant.example.com", "/fallback", ""},
+		{"10.0.0.1", "/", ""},
+	}
+	for _, c := range cases {
+		route, _, ok := r.Lookup(Host(c.host), c.path)
+		if c.want == "" {
+			if ok {
+				t.Errorf("%s%s: expected no match, got %s", c.host, c.path, route.Pool.Config().ServiceName)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s%s: expected %s, got no match", c.host, c.path, c.want)
+			continue
+		}
+		if got := route.Pool.Config().ServiceName; got != c.want {
+			t.Errorf("%s%s: got %s, want %s", c.host, c.path, got, c.want)
+		}
+	}
+
+	var keys []string
+	for _, ri := range r.Routes() {
+		keys = append(keys, ri.Key)
+	}
+	want := []string{"*.eu.example.com/", "*.example.com/", "*/fallback", "www.example.com/"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("routes: got %v, want %v", keys, want)
+	}
+}
+
+func TestRouterInvalidHostPatterns(t *testing.T) {
+	for _, h := range []Host{"", "*example.com", "a.*.example.com", "*.*.example.com"} {
+		if err := ValidateHost(h); err == nil {
+			t.Errorf("%q: expected an error", h)
+		}
+		if r := NewRouter().Add(h, "/", []*url.URL{mustParseURL("http://localhost:8001")}); len(r.Routes()) != 0 {
+			t.Errorf("%q: invalid pattern was added", h)
+		}
+	}
+	if _, err := BuildFromConfig([]InitialRoutes{{Domain: "a.*.com", PathPrefix: "/", Ports: []string{"8001"}}}); err == nil {
+		t.Error("BuildFromConfig accepted an invalid host pattern")
+	}
+}
+
+func TestRouterRemove(t *testing.T) {
+	r := newTestRouter()
+	hosts := len(r.hosts)
+
+	next, hc := r.Remove(Host("WWW.example.com"), "/api/v1/")
+	if hc == nil || hc != r.healthcheckers["www.example.com/api/v1"] {
+		t.Fatal("expected the route's health checker to be returned")
+	}
+	if _, ok := next.healthcheckers["www.example.com/api/v1"]; ok {
+		t.Error("health checker still registered")
+	}
+	if _, _, ok := next.Lookup(Host("www.example.com"), "/api/v1"); ok {
+		t.Error("removed route still matches")
+	}
+	if _, _, ok := r.Lookup(Host("www.example.com"), "/api/v1"); !ok {
+		t.Error("Remove mutated the original router")
+	}
+	if _, _, ok := next.Lookup(Host("www.example.com"), "/api"); !ok {
+		t.Error("parent route lost")
+	}
+
+	// The emptied /v1 node is pruned; untouched subtrees stay shared.
+	www := next.hosts[Host("www.example.com")]
+	if n := staticNode(www, "/api"); n == nil || len(n.static) != 0 {
+		t.Error("empty node not pruned")
+	}
+	if staticNode(www, "/users/") != staticNode(r.hosts[Host("www.example.com")], "/users/") {
+		t.Error("untouched subtree was copied")
+	}
+
+	// Removing the last route of a host drops the host.
+	next, hc = next.Remove(Host("api.example.com"), "/v1")
+	if hc == nil || len(next.hosts) != hosts-1 {
+		t.Errorf("expected api.example.com to be dropped, got %d hosts", len(next.hosts))
+	}
+
+	if same, hc := next.Remove(Host("www.example.com"), "/missing"); same != next || hc != nil {
+		t.Error("removing a missing route should return the router unchanged")
+	}
+}
+
+func TestRouterRemoveMatchAndReplace(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9001")}
+	get := &Match{Methods: []string{"GET"}}
+	r := NewRouter().
+		Add(Host("api.com"), "/items/:id", backend).
+		AddWithOptions(Host("api.com"), "/items/:id", backend, RouteOptions{Match: get})
+
+	next, hc := r.RemoveMatch(Host("api.com"), "/items/:id", &Match{Methods: []string{"get"}})
+	if hc == nil {
+		t.Fatal("expected the conditional route to be removed")
+	}
+	if got := len(next.Routes()); got != 1 || next.Routes()[0].Route.Match != nil {
+		t.Errorf("expected only the plain route to remain, got %d routes", got)
+	}
+
+	old := r.healthcheckers["api.com/items/:id"]
+	replaced, displaced := r.Replace(Host("api.com"), "/items/:id", []*url.URL{mustParseURL("http://localhost:9002")}, RouteOptions{})
+	if displaced != old {
+		t.Error("Replace did not return the displaced health checker")
+	}
+	route, _, _ := replaced.Lookup(Host("api.com"), "/items/1")
+	if b, err := route.NextBackend(); err != nil || b.URL.Port() != "9002" {
+		t.Errorf("expected the replacement backend, got %v, %v", b, err)
+	}
+	if _, displaced := r.Replace(Host("api.com"), "/new", backend, RouteOptions{}); displaced != nil {
+		t.Error("a new route should not displace a health checker")
+	}
+}
+
+func TestRouterRenamedCatchAll(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9001")}
+	get := &Match{Methods: []string{"GET"}}
+	r := NewRouter().
+		Add(Host("a.com"), "/x/*a", backend).
+		AddWithOptions(Host("a.com"), "/x/*a", backend, RouteOptions{Match: get})
+	old := r.healthcheckers["a.com/x/*a"]
+
+	next, displaced := r.Replace(Host("a.com"), "/x/*b", backend, RouteOptions{})
+	if displaced == nil || displaced != old {
+		t.Error("Replace did not return the health checker of the renamed catch-all")
+	}
+	if _, ok := next.healthcheckers["a.com/x/*a"]; ok {
+		t.Error("the replaced catch-all kept its health checker")
+	}
+	if _, ok := next.healthcheckers["a.com/x/*b"]; !ok {
+		t.Error("the new catch-all has no health checker")
+	}
+	if _, ok := next.healthcheckers[MatchKey(Host("a.com"), "/x/*a", get)]; !ok {
+		t.Error("the conditional route under the catch-all lost its health checker")
+	}
+	if got := len(next.Routes()); got != 2 {
+		t.Errorf("expected the new and the conditional route, got %d routes", got)
+	}
+	if got := len(r.AddWithOptions(Host("a.com"), "/x/*b", backend, RouteOptions{}).healthcheckers); got != 2 {
+		t.Errorf("expected 2 health checkers after AddWithOptions, got %d", got)
+	}
+}
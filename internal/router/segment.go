@@ -0,0 +1,119 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Named parameter constraints, usable as ":id{int}". Any other constraint is
+// a regular expression that must match the whole segment, e.g. ":id{[0-9]+}".
+var namedConstraints = map[string]string{
+	"int":   `[0-9]+`,
+	"hex":   `[0-9a-fA-F]+`,
+	"alpha": `[A-Za-z]+`,
+	"alnum": `[A-Za-z0-9]+`,
+	"slug":  `[a-z0-9]+(?:-[a-z0-9]+)*`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
+// segment is a parsed path segment. Exactly one of the kinds applies:
+// static text, a parameter (optionally constrained) or a trailing wildcard
+// (optionally named, capturing the rest of the path).
+type segment struct {
+	param      string
+	constraint *regexp.Regexp
+	wildcard   bool
+	catchAll   string
+}
+
+// parseSegment parses one segment of a route path:
+//
+//	api            static
+//	:id            any single segment, captured as "id"
+//	:id{int}       a single segment matching a named constraint
+//	:id{[0-9]+}    a single segment matching a regular expression
+//	*              the rest of the path, possibly empty
+//	*rest          the rest of the path, captured as "rest"
+func parseSegment(seg string) (segment, error) {
+	switch {
+	case seg == "*":
+		return segment{wildcard: true}, nil
+	case seg[0] == '*':
+		name := seg[1:]
+		if !validParamName(name) {
+			return segment{}, fmt.Errorf("invalid catch-all name %q", name)
+		}
+		return segment{wildcard: true, catchAll: name}, nil
+	case seg[0] != ':':
+		return segment{}, nil
+	}
+
+	name, constraint := seg[1:], ""
+	if i := strings.IndexByte(name, '{'); i != -1 {
+		if !strings.HasSuffix(name, "}") {
+			return segment{}, fmt.Errorf("unterminated constraint in %q", seg)
+		}
+		name, constraint = name[:i], name[i+1:len(name)-1]
+	}
+	if !validParamName(name) {
+		return segment{}, fmt.Errorf("invalid parameter name in %q", seg)
+	}
+	s := segment{param: name}
+	if constraint != "" {
+		if expr, ok := namedConstraints[constraint]; ok {
+			constraint = expr
+		}
+		re, err := regexp.Compile("^(?:" + constraint + ")$")
+		if err != nil {
+			return segment{}, fmt.Errorf("invalid constraint in %q: %w", seg, err)
+		}
+		s.constraint = re
+	}
+	return s, nil
+}
+
+// paramNames returns the names a route path captures, parameters and
+// named wildcards alike.
+func paramNames(path string) map[string]bool {
+	names := make(map[string]bool)
+	for _, seg := range pathToSegments(normalizePrefix(path)) {
+		if s, err := parseSegment(seg); err == nil {
+			switch {
+			case s.param != "":
+				names[s.param] = true
+			case s.catchAll != "":
+				names[s.catchAll] = true
+			}
+		}
+	}
+	return names
+}
+
+func validParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidatePath reports whether path is a usable route path: every segment
+// parses and a wildcard, if any, is the last segment.
+func ValidatePath(path string) error {
+	segments := pathToSegments(normalizePrefix(path))
+	for i, seg := range segments {
+		s, err := parseSegment(seg)
+		if err != nil {
+			return fmt.Errorf("path %s: %w", path, err)
+		}
+		if s.wildcard && i != len(segments)-1 {
+			return fmt.Errorf("path %s: wildcard must be the last segment", path)
+		}
+	}
+	return nil
+}
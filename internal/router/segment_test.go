@@ -0,0 +1,73 @@
+package router
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRouterConstrainedParams(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r := NewRouter()
+	r = r.Add(Host("api.com"), "/items/new", backend)
+	r = r.Add(Host("api.com"), "/items/:id{int}", backend)
+	r = r.Add(Host("api.com"), "/items/:uuid{uuid}", backend)
+	r = r.Add(Host("api.com"), "/items/:code{[a-z]{3}}", backend)
+	r = r.Add(Host("api.com"), "/items/:name", backend)
+	r = r.Add(Host("api.com"), "/items/*rest", backend)
+
+	cases := []struct {
+		path   string
+		want   string
+		params Params
+	}{
+		{"/items/new", "api.com/items/new", nil},
+		{"/items/42", "api.com/items/:id{int}", Params{{"id", "42"}}},
+		{"/items/0b8e5a6e-3f5c-4c1e-9f5e-2d6c8c1a7b3d", "api.com/items/:uuid{uuid}", Params{{"uuid", "0b8e5a6e-3f5c-4c1e-9f5e-2d6c8c1a7b3d"}}},
+		{"/items/abc", "api.com/items/:code{[a-z]{3}}", Params{{"code", "abc"}}},
+		{"/items/abcd", "api.com/items/:name", Params{{"name", "abcd"}}},
+		{"/items/42/parts/7", "api.com/items/*rest", Params{{"rest", "42/parts/7"}}},
+		{"/items", "api.com/items/*rest", Params{{"rest", ""}}},
+	}
+	for _, c := range cases {
+		route, params, ok := r.Lookup(Host("api.com"), c.path)
+		if !ok {
+			t.Errorf("%s: no match", c.path)
+			continue
+		}
+		if got := route.Pool.Config().ServiceName; got != c.want {
+			t.Errorf("%s: got %s, want %s", c.path, got, c.want)
+		}
+		if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%s: got params %v, want %v", c.path, params, c.params)
+		}
+	}
+}
+
+func TestRouterConstraintFallsBack(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r := NewRouter().Add(Host("api.com"), "/users/:id{int}/posts", backend)
+
+	if _, _, ok := r.Lookup(Host("api.com"), "/users/bob/posts"); ok {
+		t.Error("constraint should reject a non-numeric id")
+	}
+	if _, params, ok := r.Lookup(Host("api.com"), "/users/7/posts"); !ok || params.Get("id") != "7" {
+		t.Errorf("expected id 7, got %v (found %v)", params, ok)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	for _, p := range []string{"/", "/a/:id", "/a/:id{int}", "/a/:id{[0-9]{2,4}}", "/a/*", "/a/*rest", "*"} {
+		if err := ValidatePath(p); err != nil {
+			t.Errorf("%s: unexpected error %v", p, err)
+		}
+	}
+	for _, p := range []string{"/a/:", "/a/:id{int", "/a/:id{[0-9}", "/a/*/b", "/a/*rest/b", "/a/*re st"} {
+		if err := ValidatePath(p); err == nil {
+			t.Errorf("%s: expected an error", p)
+		}
+		if r := NewRouter().Add(Host("api.com"), p, []*url.URL{mustParseURL("http://localhost:9000")}); len(r.Routes()) != 0 {
+			t.Errorf("%s: invalid path was added", p)
+		}
+	}
+}
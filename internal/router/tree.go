@@ -0,0 +1,457 @@
+package router
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Param is a path parameter captured by a route.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Params holds the parameters of a matched route in path order. It is nil
+// for routes without parameters.
+type Params []Param
+
+// Get returns the value of the named parameter, or "" if there is none.
+func (ps Params) Get(name string) string {
+	for _, p := range ps {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+/*
+	The routing tree of a host is a compressed radix tree over cleaned paths
+	("/users/:id/posts"). Static text is shared byte-wise between routes and
+	split only where routes diverge, so "/api/users" and "/api/items" share
+	one "/api/" node. Parameter and wildcard segments get their own nodes,
+	hanging off the static node that ends with the slash before them:
+
+		""
+		└── "/api/"
+		    ├── "users"        route /api/users
+		    ├── "items"        route /api/items
+		    │   └── "/"
+		    │       └── :id    route /api/items/:id
+		    └── *              route /api/*
+
+	Like the rest of the router, nodes are never modified once published:
+	insert and remove copy the nodes on the way to the change and share
+	every other subtree with the previous version.
+*/
+
+type node struct {
+	prefix   string  // static text of the node; empty for parameter and wildcard nodes
+	indices  string  // first byte of each static child, in the order of static
+	static   []*node // static children
+	params   []*node // parameter children in lookup order, see sortParams
+	wildcard *node
+	routes   []*Route // ordered by precedence, see withRoute
+
+	// Set on parameter and wildcard nodes only.
+	segment    string         // source text, e.g. ":id{int}" or "*rest"
+	paramName  string         // parameter name
+	constraint *regexp.Regexp // restricts the values paramName accepts
+	catchAll   string         // wildcard parameter capturing the rest of the path
+}
+
+// token is a piece of a route path: static text, or a parameter or
+// wildcard segment.
+type token struct {
+	static  string
+	segment string // source text of a parameter or wildcard segment
+}
+
+// tokenize splits a path that passed ValidatePath into tokens. Static text
+// runs up to and including the slash before a parameter or wildcard.
+func tokenize(path string) []token {
+	var tokens []token
+	var static strings.Builder
+	for _, seg := range pathToSegments(normalizePrefix(path)) {
+		static.WriteByte('/')
+		if seg[0] != ':' && seg[0] != '*' {
+			static.WriteString(seg)
+			continue
+		}
+		tokens = append(tokens, token{static: static.String()}, token{segment: seg})
+		static.Reset()
+	}
+	if static.Len() > 0 {
+		tokens = append(tokens, token{static: static.String()})
+	} else if len(tokens) == 0 {
+		tokens = append(tokens, token{static: "/"})
+	}
+	return tokens
+}
+
+// countParams returns the number of parameters a route path captures.
+func countParams(tokens []token) int {
+	n := 0
+	for _, t := range tokens {
+		if t.segment != "" && t.segment != "*" {
+			n++
+		}
+	}
+	return n
+}
+
+// newSegmentNode creates the node for a parameter or wildcard segment that
+// passed ValidatePath.
+func newSegmentNode(seg string) *node {
+	s, _ := parseSegment(seg)
+	return &node{
+		segment:    seg,
+		paramName:  s.param,
+		constraint: s.constraint,
+		catchAll:   s.catchAll,
+	}
+}
+
+// insert adds a new route to the node tree while preserving immutability.
+// It uses a copy-on-write approach, which performs a shallow copy of the current
+// node and its child slices. Unchanged subtrees are reused, while only modified
+// branches are cloned. This allows multiple router versions to coexist safely,
+// sharing unchanged nodes without affecting previous instances. It also
+// returns the route that route replaced, the one with the same conditions at
+// the same place, which may sit under a catch-all of another name.
+func (n *node) insert(tokens []token, route *Route) (*node, *Route) {
+	if len(tokens) == 0 {
+		return n.withRoute(route)
+	}
+
+	tok := tokens[0]
+	copied := *n
+	var replaced *Route
+	switch {
+	case tok.segment == "":
+		replaced = copied.insertStatic(tok.static, tokens[1:], route)
+	case tok.segment[0] == '*':
+		// A node has at most one wildcard; a renamed catch-all takes it over.
+		child := n.wildcard
+		if child == nil || child.segment != tok.segment {
+			renamed := newSegmentNode(tok.segment)
+			if child != nil {
+				renamed.routes = child.routes
+			}
+			child = renamed
+		}
+		copied.wildcard, replaced = child.insert(tokens[1:], route)
+	default:
+		params := make([]*node, 0, len(n.params)+1)
+		child := newSegmentNode(tok.segment)
+		for _, p := range n.params {
+			if p.segment == tok.segment {
+				child = p
+			} else {
+				params = append(params, p)
+			}
+		}
+		child, replaced = child.insert(tokens[1:], route)
+		copied.params = sortParams(append(params, child))
+	}
+	return &copied, replaced
+}
+
+// insertStatic inserts static text s followed by rest below n, which must
+// be a private copy, and returns the route it replaced. The static child
+// sharing a first byte with s is split where the two diverge.
+func (n *node) insertStatic(s string, rest []token, route *Route) *Route {
+	i := strings.IndexByte(n.indices, s[0])
+	if i == -1 {
+		child, _ := (&node{prefix: s}).insert(rest, route)
+		n.indices += s[:1]
+		n.static = append(append([]*node(nil), n.static...), child)
+		return nil
+	}
+
+	child := n.static[i]
+	l := commonPrefix(child.prefix, s)
+	if l < len(child.prefix) {
+		tail := *child
+		tail.prefix = child.prefix[l:]
+		child = &node{prefix: child.prefix[:l], indices: tail.prefix[:1], static: []*node{&tail}}
+	}
+	var replaced *Route
+	if l < len(s) {
+		child, replaced = child.insert(append([]token{{static: s[l:]}}, rest...), route)
+	} else {
+		child, replaced = child.insert(rest, route)
+	}
+	n.static = append([]*node(nil), n.static...)
+	n.static[i] = child
+	return replaced
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// sortParams orders parameter nodes the way lookup tries them: constrained
+// parameters before plain ones, each group sorted by segment.
+func sortParams(params []*node) []*node {
+	sort.Slice(params, func(i, j int) bool {
+		a, b := params[i], params[j]
+		if (a.constraint != nil) != (b.constraint != nil) {
+			return a.constraint != nil
+		}
+		return a.segment < b.segment
+	})
+	return params
+}
+
+// withRoute returns a copy of n with route added, and the route with the
+// same conditions it replaced, if any. Routes are kept most specific first,
+// then by key, so the order does not depend on the order they were added in.
+func (n *node) withRoute(route *Route) (*node, *Route) {
+	copied := *n
+	copied.routes = make([]*Route, 0, len(n.routes)+1)
+	var replaced *Route
+	for _, existing := range n.routes {
+		if existing.Match.String() != route.Match.String() {
+			copied.routes = append(copied.routes, existing)
+		} else {
+			replaced = existing
+		}
+	}
+	copied.routes = append(copied.routes, route)
+	sort.SliceStable(copied.routes, func(i, j int) bool {
+		a, b := copied.routes[i].Match, copied.routes[j].Match
+		if a.specificity() != b.specificity() {
+			return a.specificity() > b.specificity()
+		}
+		return a.String() < b.String()
+	})
+	return &copied, replaced
+}
+
+// remove returns a copy of n without the route for path with conditions
+// match, along with the removed route, or n itself and nil if there is no
+// such route. Nodes left without routes or children are pruned and static
+// nodes left with a single static child are merged into it, so the tree
+// stays compressed; untouched subtrees stay shared.
+func (n *node) remove(tokens []token, path, match string) (*node, *Route) {
+	if len(tokens) == 0 {
+		for i, route := range n.routes {
+			if normalizePrefix(route.Prefix) == path && route.Match.String() == match {
+				copied := *n
+				copied.routes = append(append([]*Route(nil), n.routes[:i]...), n.routes[i+1:]...)
+				return &copied, route
+			}
+		}
+		return n, nil
+	}
+
+	tok := tokens[0]
+	copied := *n
+	switch {
+	case tok.segment == "":
+		i := strings.IndexByte(n.indices, tok.static[0])
+		if i == -1 || !strings.HasPrefix(tok.static, n.static[i].prefix) {
+			return n, nil
+		}
+		rest := tokens[1:]
+		if s := tok.static[len(n.static[i].prefix):]; s != "" {
+			rest = append([]token{{static: s}}, rest...)
+		}
+		child, removed := n.static[i].remove(rest, path, match)
+		if removed == nil {
+			return n, nil
+		}
+		if child.empty() {
+			copied.indices = n.indices[:i] + n.indices[i+1:]
+			copied.static = append(append([]*node(nil), n.static[:i]...), n.static[i+1:]...)
+		} else {
+			copied.static = append([]*node(nil), n.static...)
+			copied.static[i] = child.compact()
+		}
+		return &copied, removed
+
+	case tok.segment[0] == '*':
+		if n.wildcard == nil {
+			return n, nil
+		}
+		child, removed := n.wildcard.remove(tokens[1:], path, match)
+		if removed == nil {
+			return n, nil
+		}
+		copied.wildcard = child
+		if child.empty() {
+			copied.wildcard = nil
+		}
+		return &copied, removed
+
+	default:
+		for i, p := range n.params {
+			if p.segment != tok.segment {
+				continue
+			}
+			child, removed := p.remove(tokens[1:], path, match)
+			if removed == nil {
+				return n, nil
+			}
+			copied.params = append([]*node(nil), n.params[:i]...)
+			if !child.empty() {
+				copied.params = append(copied.params, child)
+			}
+			copied.params = append(copied.params, n.params[i+1:]...)
+			return &copied, removed
+		}
+		return n, nil
+	}
+}
+
+func (n *node) empty() bool {
+	return len(n.routes) == 0 && len(n.static) == 0 && len(n.params) == 0 && n.wildcard == nil
+}
+
+// compact merges a static node that only leads to one static child into
+// that child.
+func (n *node) compact() *node {
+	if n.segment != "" || len(n.routes) > 0 || len(n.params) > 0 || n.wildcard != nil || len(n.static) != 1 {
+		return n
+	}
+	merged := *n.static[0]
+	merged.prefix = n.prefix + merged.prefix
+	return &merged
+}
+
+// match returns the first route of n whose conditions req meets.
+func (n *node) match(req *http.Request) *Route {
+	for _, route := range n.routes {
+		if route.Match.matches(req) {
+			return route
+		}
+	}
+	return nil
+}
+
+// lookupState is shared by the recursive lookup of one request.
+type lookupState struct {
+	req       *http.Request
+	params    Params
+	maxParams int // capacity of params, allocated on the first parameter
+}
+
+func (s *lookupState) push(name, value string) {
+	if s.params == nil {
+		s.params = make(Params, 0, s.maxParams)
+	}
+	s.params = append(s.params, Param{Name: name, Value: value})
+}
+
+// lookup matches path, the part of a cleaned request path after n's
+// prefix. The children of n are tried in a fixed order of priority: the
+// static child, then constrained parameters, then plain parameters, then
+// the wildcard, backtracking when a branch has no matching route.
+// Parameters of the same kind are tried in segment order.
+func (n *node) lookup(path string, s *lookupState) *Route {
+	if path == "" {
+		if r := n.match(s.req); r != nil {
+			return r
+		}
+	}
+
+	// Try to match static text.
+	c := byte('/')
+	if path != "" {
+		c = path[0]
+	}
+	if i := strings.IndexByte(n.indices, c); i != -1 {
+		child := n.static[i]
+		if strings.HasPrefix(path, child.prefix) {
+			if r := child.lookup(path[len(child.prefix):], s); r != nil {
+				return r
+			}
+		} else if child.wildcard != nil && len(child.prefix) == len(path)+1 && child.prefix[len(path)] == '/' && child.prefix[:len(path)] == path {
+			// "/x/*" also matches "/x".
+			if r := child.wildcard.match(s.req); r != nil {
+				if child.wildcard.catchAll != "" {
+					s.push(child.wildcard.catchAll, "")
+				}
+				return r
+			}
+		}
+	}
+
+	// Try to match the segment as a parameter, constrained ones first.
+	if path != "" && len(n.params) > 0 {
+		end := strings.IndexByte(path, '/')
+		if end == -1 {
+			end = len(path)
+		}
+		seg := path[:end]
+		for _, child := range n.params {
+			if child.constraint != nil && !child.constraint.MatchString(seg) {
+				continue
+			}
+			mark := len(s.params)
+			s.push(child.paramName, seg)
+			if r := child.lookup(path[end:], s); r != nil {
+				return r
+			}
+			s.params = s.params[:mark]
+		}
+	}
+
+	// Try to match the rest as a wildcard.
+	if n.wildcard != nil {
+		if r := n.wildcard.match(s.req); r != nil {
+			if n.wildcard.catchAll != "" {
+				s.push(n.wildcard.catchAll, path)
+			}
+			return r
+		}
+	}
+
+	/*
+		!INFO:
+		When no matching child is found, we intentionally avoid returning the
+		current node’s route even if it exists. Allowing that fallback could cause
+		unexpected behavior. For example, if a route is registered for "/api" and a
+		request comes in for "/api/v1", we shouldn’t return the "/api" route unless
+		a wildcard (e.g. "/*" or "/api/*") was explicitly configured to match it.
+		This ensures routes are matched strictly unless a catch-all is defined.
+	*/
+
+	return nil
+}
+
+func (n *node) walk(fn func(*Route)) {
+	for _, route := range n.routes {
+		fn(route)
+	}
+	for _, child := range n.static {
+		child.walk(fn)
+	}
+	for _, child := range n.params {
+		child.walk(fn)
+	}
+	if n.wildcard != nil {
+		n.wildcard.walk(fn)
+	}
+}
+
+// cleanPath returns a request path in the form route paths are stored in:
+// a leading slash and no empty or trailing segments. Paths already in that
+// form, the common case, are returned without allocating.
+func cleanPath(p string) string {
+	if p == "/" {
+		return p
+	}
+	if len(p) > 1 && p[0] == '/' && p[len(p)-1] != '/' && p[len(p)-1] > ' ' && !strings.Contains(p, "//") {
+		return p
+	}
+	segments := pathToSegments(normalizePrefix(p))
+	return "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := map[string][]token{
+		"/":                {{static: "/"}},
+		"/api/v1/":         {{static: "/api/v1"}},
+		"/*":               {{static: "/"}, {segment: "*"}},
+		"/users/:id/posts": {{static: "/users/"}, {segment: ":id"}, {static: "/posts"}},
+		"/a//:x{int}/*r":   {{static: "/a/"}, {segment: ":x{int}"}, {static: "/"}, {segment: "*r"}},
+	}
+	for path, want := range cases {
+		if got := tokenize(path); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestTreeCompression(t *testing.T) {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r := NewRouter()
+	for _, p := range []string{"/api/users", "/api/items", "/api/items/:id", "/api/*"} {
+		r = r.Add(Host("a.com"), p, backend)
+	}
+	root := r.hosts[Host("a.com")]
+
+	// One shared "/api/" node with "users" and "items" below it.
+	api := staticNode(root, "/api/")
+	if api == nil || api.prefix != "/api/" || len(api.static) != 2 || api.wildcard == nil {
+		t.Fatalf("unexpected tree below /api/: %+v", api)
+	}
+	if items := staticNode(root, "/api/items/"); items == nil || len(items.params) != 1 {
+		t.Errorf("expected a parameter below /api/items/")
+	}
+
+	// Removing "/api/items" and its child leaves "/api/" with one static
+	// child and a wildcard; removing the wildcard merges "/api/users".
+	r, _ = r.Remove(Host("a.com"), "/api/items/:id")
+	r, _ = r.Remove(Host("a.com"), "/api/items")
+	if staticNode(r.hosts[Host("a.com")], "/api/items") != nil {
+		t.Error("emptied branch not pruned")
+	}
+	r, _ = r.Remove(Host("a.com"), "/api/*")
+	root = r.hosts[Host("a.com")]
+	if len(root.static) != 1 || root.static[0].prefix != "/api/users" {
+		t.Errorf("expected a single merged /api/users node, got %+v", root.static)
+	}
+	if _, _, ok := r.Lookup(Host("a.com"), "/api/users"); !ok {
+		t.Error("remaining route lost")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	cases := map[string]string{
+		"/":         "/",
+		"":          "/",
+		"/api":      "/api",
+		"/api/":     "/api",
+		"//api//v1": "/api/v1",
+		"api":       "/api",
+	}
+	for in, want := range cases {
+		if got := cleanPath(in); got != want {
+			t.Errorf("%q: got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLookupAllocations(t *testing.T) {
+	r := benchmarkRouter(1000)
+	for _, path := range []string{"/svc500/items", "/svc999/static/file.css", "/"} {
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, _, ok := r.Lookup(Host("bench.com"), path); !ok {
+				t.Fatalf("%s: no match", path)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: %v allocations per lookup, want 0", path, allocs)
+		}
+	}
+	// Routes with parameters allocate their Params once.
+	allocs := testing.AllocsPerRun(100, func() { r.Lookup(Host("bench.com"), "/svc500/items/42") })
+	if allocs != 1 {
+		t.Errorf("parameter lookup: %v allocations, want 1", allocs)
+	}
+}
+
+// benchmarkRouter returns a router with services*4 routes on one host.
+func benchmarkRouter(services int) *Router {
+	backend := []*url.URL{mustParseURL("http://localhost:9000")}
+	r := NewRouter().Add(Host("bench.com"), "/", backend)
+	for i := 0; i < services; i++ {
+		prefix := fmt.Sprintf("/svc%d", i)
+		r = r.Add(Host("bench.com"), prefix+"/items", backend)
+		r = r.Add(Host("bench.com"), prefix+"/items/:id", backend)
+		r = r.Add(Host("bench.com"), prefix+"/users/:id{int}/orders", backend)
+		r = r.Add(Host("bench.com"), prefix+"/static/*", backend)
+	}
+	return r
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, services := range []int{25, 250, 2500} {
+		r := benchmarkRouter(services)
+		last := fmt.Sprintf("/svc%d", services-1)
+		for _, c := range []struct{ name, path string }{
+			{"static", last + "/items"},
+			{"param", last + "/items/42"},
+			{"constrained", last + "/users/7/orders"},
+			{"wildcard", last + "/static/css/site.css"},
+		} {
+			path := c.path
+			b.Run(fmt.Sprintf("%d_routes/%s", services*4, c.name), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, _, ok := r.Lookup(Host("bench.com"), path); !ok {
+						b.Fatalf("%s: no match", path)
+					}
+				}
+			})
+		}
+	}
+}
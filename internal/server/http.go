@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/diabeney/balto/internal/health"
+	"github.com/diabeney/balto/internal/router"
 )
 
 type HTTPServer struct {
@@ -17,6 +18,8 @@ func New(addr string, proxyHandler http.Handler) *HTTPServer {
 	mux := http.NewServeMux()
 
 	mux.Handle("/health", http.HandlerFunc(health.CheckBaltoHealth))
+	mux.Handle("/livez", http.HandlerFunc(Livez))
+	mux.Handle("/readyz", Readyz(router.Current))
 	mux.Handle("/", proxyHandler)
 
 	return &HTTPServer{
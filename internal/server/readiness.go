@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/diabeney/balto/internal/router"
+)
+
+type RouteReadiness struct {
+	Key      string `json:"key"`
+	Healthy  int    `json:"healthy"`
+	Total    int    `json:"total"`
+	Swept    bool   `json:"swept"`
+	Degraded bool   `json:"degraded"`
+}
+
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Reason string           `json:"reason,omitempty"`
+	Routes []RouteReadiness `json:"routes,omitempty"`
+}
+
+// CheckReadiness reports whether rt can serve traffic: it must be loaded and
+// every route's health checker must have completed its initial sweep. With
+// strict, a route without a single healthy backend also makes Balto unready.
+func CheckReadiness(rt *router.Router, strict bool) Readiness {
+	if rt == nil {
+		return Readiness{Reason: "no router loaded"}
+	}
+
+	r := Readiness{Ready: true, Routes: []RouteReadiness{}}
+	for _, ri := range rt.Routes() {
+		rr := RouteReadiness{Key: ri.Key, Swept: ri.Healthchecker == nil || ri.Healthchecker.Swept()}
+		if ri.Route.Pool != nil {
+			for _, b := range ri.Route.Pool.List() {
+				rr.Total++
+				if b.IsHealthy() && !b.IsDraining() {
+					rr.Healthy++
+				}
+			}
+		}
+		rr.Degraded = ri.Route.Pool != nil && rr.Healthy == 0
+		r.Routes = append(r.Routes, rr)
+
+		if !r.Ready {
+			continue
+		}
+		switch {
+		case !rr.Swept:
+			r.Ready, r.Reason = false, "initial health check pending for "+rr.Key
+		case strict && rr.Degraded:
+			r.Ready, r.Reason = false, "no healthy backends for "+rr.Key
+		}
+	}
+	return r
+}
+
+// Livez answers as long as the process can serve HTTP.
+func Livez(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz returns a handler reporting CheckReadiness for the router returned by
+// current. ?strict fails readiness on degraded routes and ?verbose adds the
+// per-route healthy backend counts.
+func Readyz(current func() *router.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		res := CheckReadiness(current(), q.Has("strict"))
+		if !q.Has("verbose") {
+			res.Routes = nil
+		}
+
+		status := http.StatusOK
+		if !res.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeStatus(w, status, res)
+	})
+}
+
+func writeStatus(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/diabeney/balto/internal/core/backendpool"
+	"github.com/diabeney/balto/internal/router"
+)
+
+func TestLivez(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Livez(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	upURL, _ := url.Parse(up.URL)
+	downURL, _ := url.Parse("http://127.0.0.1:1")
+
+	pool := func() *backendpool.PoolConfig {
+		cfg := router.DefaultPoolConfig()
+		cfg.ProbePath = "/"
+		cfg.ProbeInterval = 100
+		cfg.ProbeHealthThreshold = 1
+		return &cfg
+	}
+	rt := router.NewRouter().
+		AddWithOptions("a.com", "/", []*url.URL{upURL}, router.RouteOptions{Pool: pool()}).
+		AddWithOptions("b.com", "/", []*url.URL{downURL}, router.RouteOptions{Pool: pool()})
+
+	var current *router.Router
+	h := Readyz(func() *router.Router { return current })
+	get := func(query string) (int, Readiness) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz"+query, nil))
+		var res Readiness
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return rec.Code, res
+	}
+
+	if code, res := get(""); code != http.StatusServiceUnavailable || res.Reason != "no router loaded" {
+		t.Errorf("without router: got %d %+v", code, res)
+	}
+
+	current = rt
+	if code, res := get(""); code != http.StatusServiceUnavailable || res.Ready {
+		t.Errorf("before initial sweep: got %d %+v", code, res)
+	}
+
+	rt.Start()
+	defer func() { _ = rt.Stop() }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if code, _ := get(""); code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for readiness")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Run("Verbose lists routes", func(t *testing.T) {
+		_, res := get("?verbose")
+		if len(res.Routes) != 2 {
+			t.Fatalf("expected 2 routes, got %+v", res.Routes)
+		}
+		a, b := res.Routes[0], res.Routes[1]
+		if a.Key != "a.com/" || a.Healthy != 1 || a.Total != 1 || a.Degraded || !a.Swept {
+			t.Errorf("unexpected route %+v", a)
+		}
+		if b.Key != "b.com/" || b.Healthy != 0 || b.Total != 1 || !b.Degraded {
+			t.Errorf("unexpected route %+v", b)
+		}
+
+		if _, res := get(""); res.Routes != nil {
+			t.Errorf("expected routes only in verbose mode, got %+v", res.Routes)
+		}
+	})
+
+	t.Run("Strict fails on degraded routes", func(t *testing.T) {
+		code, res := get("?strict")
+		if code != http.StatusServiceUnavailable || res.Reason != "no healthy backends for b.com/" {
+			t.Errorf("got %d %+v", code, res)
+		}
+	})
+}
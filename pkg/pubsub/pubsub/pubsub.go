@@ -0,0 +1,142 @@
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBufferSize is used when Subscribe is called with a non-positive size.
+const DefaultBufferSize = 64
+
+// Topic identifies a stream of events. Publishers own their topics and
+// document the payload type carried on each of them.
+type Topic string
+
+type Event struct {
+	Topic Topic
+	Time  time.Time
+	Data  any
+}
+
+// As returns the event payload as T, reporting whether the assertion held.
+func As[T any](e Event) (T, bool) {
+	v, ok := e.Data.(T)
+	return v, ok
+}
+
+// Bus is an in-memory, topic-based event bus.
+//
+// Publishing never blocks: each subscriber owns a bounded queue and events
+// that do not fit are dropped and counted on the subscriber. A slow consumer
+// can therefore only hurt itself, never the hot path that publishes.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+
+	published atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func New() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+var defaultBus = New()
+
+// Default returns the process-wide bus used by Balto's internal packages.
+func Default() *Bus { return defaultBus }
+
+// Publish delivers data on topic to every matching subscriber.
+func (b *Bus) Publish(topic Topic, data any) {
+	ev := Event{Topic: topic, Time: time.Now(), Data: data}
+	b.published.Add(1)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		if !s.matches(topic) {
+			continue
+		}
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped.Add(1)
+			b.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for the given topics. With no topics the
+// subscriber receives every event published on the bus.
+func (b *Bus) Subscribe(size int, topics ...Topic) *Subscription {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	s := &Subscription{
+		bus: b,
+		ch:  make(chan Event, size),
+	}
+	if len(topics) > 0 {
+		s.topics = make(map[Topic]struct{}, len(topics))
+		for _, t := range topics {
+			s.topics[t] = struct{}{}
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// Published returns the total number of events published on the bus.
+func (b *Bus) Published() uint64 { return b.published.Load() }
+
+// Dropped returns the total number of deliveries dropped across all subscribers.
+func (b *Bus) Dropped() uint64 { return b.dropped.Load() }
+
+type Subscription struct {
+	bus     *Bus
+	topics  map[Topic]struct{} // nil means all topics
+	ch      chan Event
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+func (s *Subscription) matches(t Topic) bool {
+	if s.topics == nil {
+		return true
+	}
+	_, ok := s.topics[t]
+	return ok
+}
+
+// C returns the channel events are delivered on. It is closed by Close.
+func (s *Subscription) C() <-chan Event { return s.ch }
+
+// Dropped returns the number of events this subscriber missed because its
+// queue was full.
+func (s *Subscription) Dropped() uint64 { return s.dropped.Load() }
+
+// Close unregisters the subscriber and closes its channel. It is safe to call
+// more than once.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		// Taking the write lock guarantees no Publish is mid-send on s.ch.
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		close(s.ch)
+		s.bus.mu.Unlock()
+	})
+}
@@ -0,0 +1,99 @@
+package pubsub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	b := New()
+	all := b.Subscribe(4)
+	only := b.Subscribe(4, "a")
+	defer all.Close()
+	defer only.Close()
+
+	b.Publish("a", 1)
+	b.Publish("b", "two")
+
+	t.Run("Wildcard subscriber receives every topic", func(t *testing.T) {
+		for _, want := range []Topic{"a", "b"} {
+			select {
+			case ev := <-all.C():
+				if ev.Topic != want {
+					t.Errorf("expected topic %s, got %s", want, ev.Topic)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %s", want)
+			}
+		}
+	})
+
+	t.Run("Topic subscriber is filtered", func(t *testing.T) {
+		ev := <-only.C()
+		if v, ok := As[int](ev); !ok || v != 1 {
+			t.Errorf("expected int payload 1, got %v", ev.Data)
+		}
+		select {
+		case ev := <-only.C():
+			t.Errorf("unexpected event %v", ev)
+		default:
+		}
+	})
+}
+
+func TestSlowSubscriberDrops(t *testing.T) {
+	b := New()
+	s := b.Subscribe(2)
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		b.Publish("x", i)
+	}
+
+	if s.Dropped() != 3 {
+		t.Errorf("expected 3 dropped, got %d", s.Dropped())
+	}
+	if b.Dropped() != 3 {
+		t.Errorf("expected bus dropped 3, got %d", b.Dropped())
+	}
+	if b.Published() != 5 {
+		t.Errorf("expected 5 published, got %d", b.Published())
+	}
+}
+
+func TestCloseUnsubscribes(t *testing.T) {
+	b := New()
+	s := b.Subscribe(1)
+	s.Close()
+	s.Close()
+
+	if b.Subscribers() != 0 {
+		t.Errorf("expected 0 subscribers, got %d", b.Subscribers())
+	}
+	if _, ok := <-s.C(); ok {
+		t.Error("expected closed channel")
+	}
+	// Must not panic after close.
+	b.Publish("x", nil)
+}
+
+func TestConcurrentPublishAndClose(t *testing.T) {
+	b := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		s := b.Subscribe(1)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.Publish("x", j)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,5 @@
+// Package dashboard exposes the built web dashboard to the Go binary.
+//
+// The dashboard is only embedded when building with `-tags dashboard`, after
+// the Next.js static export has been written to ui/web/dashboard/out.
+package dashboard
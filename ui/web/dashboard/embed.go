@@ -0,0 +1,23 @@
+//go:build dashboard
+
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// out holds the static export of the Next.js dashboard. The "all:" prefix is
+// required so the underscore-prefixed _next/ asset directory is included.
+//
+//go:embed all:out
+var out embed.FS
+
+// FS returns the exported dashboard rooted at its index.html.
+func FS() fs.FS {
+	sub, err := fs.Sub(out, "out")
+	if err != nil {
+		return nil
+	}
+	return sub
+}
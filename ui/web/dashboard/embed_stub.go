@@ -0,0 +1,8 @@
+//go:build !dashboard
+
+package dashboard
+
+import "io/fs"
+
+// FS returns nil when the binary was built without the dashboard.
+func FS() fs.FS { return nil }
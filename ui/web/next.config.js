@@ -0,0 +1,5 @@
+/** @type {import('next').NextConfig} */
+module.exports = {
+  // Static export embedded into the Go binary, see ui/web/dashboard.
+  output: 'export',
+}